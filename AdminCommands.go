@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"pxehub/internal/bootserver"
+	"pxehub/internal/db"
+)
+
+func dbMigrate(fs *flag.FlagSet, args []string) error {
+	paths := addPathFlags(fs)
+	if err := parseArgs(fs, args, 0, 0); err != nil {
+		return err
+	}
+
+	// Opening the database brings its schema up to date.
+	database, _, err := paths.openDB(true)
+	if err != nil {
+		return err
+	}
+
+	sqlDB, err := database.DB()
+	if err != nil {
+		return err
+	}
+	fmt.Println("Database schema is up to date")
+	return sqlDB.Close()
+}
+
+func dbBackup(fs *flag.FlagSet, args []string) error {
+	paths := addPathFlags(fs)
+	if err := parseArgs(fs, args, 1, 1); err != nil {
+		return err
+	}
+
+	database, _, err := paths.openDB(false)
+	if err != nil {
+		return err
+	}
+
+	if err := db.Backup(fs.Arg(0), database); err != nil {
+		return err
+	}
+	fmt.Printf("Backed up database to %s\n", fs.Arg(0))
+	return nil
+}
+
+func configCheck(fs *flag.FlagSet, args []string) error {
+	paths := addPathFlags(fs)
+	if err := parseArgs(fs, args, 0, 0); err != nil {
+		return err
+	}
+
+	path, err := paths.configPath()
+	if err != nil {
+		return err
+	}
+	cfg, err := paths.load()
+	if err != nil {
+		return err
+	}
+
+	// The DHCP server has checks of its own, such as dnsmasq --test.
+	server, err := bootserver.New(bootConfig(cfg), nil)
+	if err == nil {
+		err = server.Test()
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	fmt.Printf("%s is valid\n", path)
+	return nil
+}
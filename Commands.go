@@ -0,0 +1,209 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"pxehub/internal/config"
+	"pxehub/internal/db"
+
+	"gorm.io/gorm"
+)
+
+// command is a pxehub subcommand, named by one or two words, e.g. "host add".
+type command struct {
+	Name    string
+	Args    string
+	Summary string
+	Run     func(fs *flag.FlagSet, args []string) error
+}
+
+var commands = []command{
+	{"serve", "", "run the DHCP, TFTP and HTTP servers (the default)", serve},
+	{"host add", "MAC NAME", "register a host", hostAdd},
+	{"host list", "", "list hosts", hostList},
+	{"host rm", "MAC|NAME...", "delete hosts", hostRm},
+	{"host set-task", "MAC|NAME TASK|none", "set or clear a host's task", hostSetTask},
+	{"host import", "FILE", "create or update hosts from CSV", hostImport},
+	{"host export", "", "write hosts as CSV to stdout", hostExport},
+	{"task import", "FILE...", "create or update tasks from JSON or script files", taskImport},
+	{"task export", "[NAME...]", "write tasks as JSON to stdout", taskExport},
+	{"wifikey import", "FILE", "add wifi keys, one per line", wifiKeyImport},
+	{"wifikey list", "", "list wifi keys", wifiKeyList},
+	{"db migrate", "", "create or upgrade the database schema", dbMigrate},
+	{"db backup", "FILE", "write a copy of the database", dbBackup},
+	{"config check", "", "validate the config and the DHCP server settings", configCheck},
+}
+
+// usageError is returned by commands called with the wrong arguments.
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+// findCommand returns the command named by the first one or two words of
+// args, and the remaining arguments.
+func findCommand(args []string) (*command, []string) {
+	for i := range commands {
+		words := strings.Fields(commands[i].Name)
+		if len(args) >= len(words) && slices.Equal(args[:len(words)], words) {
+			return &commands[i], args[len(words):]
+		}
+	}
+	return nil, args
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: pxehub [command] [flags] [args]\n\nCommands:\n")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", cmd.Name, cmd.Args, cmd.Summary)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\nRun pxehub <command> -h for the flags of a command.\n")
+}
+
+// runCommand runs the command named by args, with no command meaning serve,
+// and returns the exit status.
+func runCommand(args []string) int {
+	if len(args) > 0 && (args[0] == "help" || args[0] == "-h" || args[0] == "--help") {
+		printUsage(os.Stdout)
+		return 0
+	}
+
+	cmd, rest := findCommand(args)
+	if cmd == nil {
+		if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+			fmt.Fprintf(os.Stderr, "pxehub: unknown command %q\n\n", strings.Join(args[:min(2, len(args))], " "))
+			printUsage(os.Stderr)
+			return 2
+		}
+		cmd = &commands[0]
+	}
+
+	fs := flag.NewFlagSet("pxehub "+cmd.Name, flag.ContinueOnError)
+	fs.Usage = func() {
+		summary := strings.ToUpper(cmd.Summary[:1]) + cmd.Summary[1:]
+		fmt.Fprintf(fs.Output(), "Usage: pxehub %s [flags] %s\n\n%s.\n\nFlags:\n", cmd.Name, cmd.Args, summary)
+		fs.PrintDefaults()
+	}
+
+	err := cmd.Run(fs, rest)
+	var usage usageError
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.As(err, &usage):
+		fmt.Fprintf(os.Stderr, "pxehub %s: %v\n", cmd.Name, err)
+		fs.Usage()
+		return 2
+	default:
+		fmt.Fprintf(os.Stderr, "pxehub %s: %v\n", cmd.Name, err)
+		return 1
+	}
+}
+
+// parseArgs parses the flags in args and checks the number of positional
+// arguments, which is at least minArgs and at most maxArgs, or any number
+// if maxArgs is -1.
+func parseArgs(fs *flag.FlagSet, args []string, minArgs, maxArgs int) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return usageError(err.Error())
+	}
+	if fs.NArg() < minArgs || (maxArgs >= 0 && fs.NArg() > maxArgs) {
+		return usageError("wrong number of arguments")
+	}
+	return nil
+}
+
+// pathFlags are the flags that locate the config file and pxehub's files.
+// Paths given as flags override the config file.
+type pathFlags struct {
+	config *string
+	paths  map[string]*string
+}
+
+func addPathFlags(fs *flag.FlagSet) *pathFlags {
+	return &pathFlags{
+		config: fs.String("config", os.Getenv(config.EnvPrefix+"CONFIG"), "path to the config file, YAML or KEY=VALUE"),
+		paths: map[string]*string{
+			"DATA_DIR":    fs.String("data-dir", "", "base directory for pxehub's files (default "+config.DefaultDataDir+")"),
+			"TFTP_DIR":    fs.String("tftp-dir", "", "TFTP root (default <data-dir>/tftp)"),
+			"EXTRAS_DIR":  fs.String("extras-dir", "", "directory served under /extras (default <data-dir>/http)"),
+			"DB_PATH":     fs.String("db", "", "SQLite database (default <data-dir>/pxehub.db)"),
+			"RUNTIME_DIR": fs.String("runtime-dir", "", "directory for generated dnsmasq files (default <data-dir>/run)"),
+		},
+	}
+}
+
+// overrides returns the config keys set by flags.
+func (p *pathFlags) overrides() map[string]string {
+	overrides := map[string]string{}
+	for key, val := range p.paths {
+		if *val != "" {
+			overrides[key] = *val
+		}
+	}
+	return overrides
+}
+
+// configPath returns the config file to read.
+func (p *pathFlags) configPath() (string, error) {
+	dataDir := *p.paths["DATA_DIR"]
+	if dataDir == "" {
+		dataDir = os.Getenv(config.EnvPrefix + "DATA_DIR")
+	}
+	return config.FindPath(*p.config, dataDir)
+}
+
+// load reads and validates the config file.
+func (p *pathFlags) load() (*config.Config, error) {
+	path, err := p.configPath()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := config.Load(path, p.overrides())
+	if err != nil {
+		return nil, fmt.Errorf("invalid config:\n%w", err)
+	}
+	return cfg, nil
+}
+
+// openDB opens the database given by --db or else by the config, which is
+// returned too if it was read. Unless create is set the database must
+// already exist, so a mistyped path is not silently created.
+func (p *pathFlags) openDB(create bool) (*gorm.DB, *config.Config, error) {
+	var cfg *config.Config
+	path := *p.paths["DB_PATH"]
+	if path == "" {
+		var err error
+		if cfg, err = p.load(); err != nil {
+			return nil, nil, err
+		}
+		path = cfg.DBPath
+	}
+
+	if !create {
+		if _, err := os.Stat(path); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return db.OpenDB(path), cfg, nil
+}
+
+func newTable() *tabwriter.Writer {
+	return tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"pxehub/internal/db"
+
+	"gorm.io/gorm"
+)
+
+// findHost looks a host up by MAC address or, failing that, by name.
+func findHost(arg string, database *gorm.DB) (*db.Host, error) {
+	var host *db.Host
+	var err error
+	if _, macErr := net.ParseMAC(arg); macErr == nil {
+		host, err = db.GetHostByMAC(arg, database)
+	} else {
+		host, err = db.GetHostByName(arg, database)
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("host %s not found", arg)
+	}
+	return host, err
+}
+
+// findTask looks a task up by ID or name.
+func findTask(arg string, database *gorm.DB) (*db.Task, error) {
+	var task *db.Task
+	var err error
+	if _, idErr := strconv.Atoi(arg); idErr == nil {
+		task, err = db.GetTaskByID(arg, database)
+	} else {
+		task, err = db.GetTaskByName(arg, database)
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("task %s not found", arg)
+	}
+	return task, err
+}
+
+// labelFlag defines a flag that may be given several times, collecting its
+// values.
+func labelFlag(fs *flag.FlagSet, name, usage string) *[]string {
+	var values []string
+	fs.Func(name, usage, func(value string) error {
+		values = append(values, value)
+		return nil
+	})
+	return &values
+}
+
+func hostAdd(fs *flag.FlagSet, args []string) error {
+	paths := addPathFlags(fs)
+	taskArg := fs.String("task", "", "task to boot, by ID or name")
+	permanent := fs.Bool("permanent", false, "keep the task after it has booted")
+	reservedIP := fs.String("ip", "", "reserved IP address")
+	dhcpHostname := fs.String("dhcp-hostname", "", "hostname handed out with the reserved address")
+	labels := labelFlag(fs, "label", "add a key=value label, may be repeated")
+	if err := parseArgs(fs, args, 2, 2); err != nil {
+		return err
+	}
+	labelMap, err := db.ParseLabels(strings.Join(*labels, ","))
+	if err != nil {
+		return err
+	}
+
+	database, cfg, err := paths.openDB(false)
+	if err != nil {
+		return err
+	}
+
+	var taskID int
+	if *taskArg != "" {
+		task, err := findTask(*taskArg, database)
+		if err != nil {
+			return err
+		}
+		taskID = task.ID
+	}
+
+	// Without a config the reservation is not checked against the scopes.
+	var subnets []*net.IPNet
+	if cfg != nil {
+		subnets = bootConfig(cfg).Subnets()
+	}
+
+	var host *db.Host
+	err = database.Transaction(func(tx *gorm.DB) error {
+		host, err = db.CreateHost(fs.Arg(0), fs.Arg(1), taskID, *permanent, tx)
+		if err != nil {
+			return err
+		}
+		if err := db.SetHostLabels(labelMap, host.ID, tx); err != nil {
+			return err
+		}
+		return db.SetHostReservation(*reservedIP, *dhcpHostname, subnets, host.ID, tx)
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Added host %s (%s) with ID %d\n", host.Name, host.Mac, host.ID)
+	return nil
+}
+
+func hostList(fs *flag.FlagSet, args []string) error {
+	paths := addPathFlags(fs)
+	name := fs.String("name", "", "only hosts whose name contains this")
+	mac := fs.String("mac", "", "only hosts whose MAC address contains this")
+	labels := labelFlag(fs, "label", "only hosts with this key=value label, or with this key; may be repeated")
+	if err := parseArgs(fs, args, 0, 0); err != nil {
+		return err
+	}
+	labelFilter, err := db.ParseLabelFilter(strings.Join(*labels, ","))
+	if err != nil {
+		return err
+	}
+
+	database, _, err := paths.openDB(false)
+	if err != nil {
+		return err
+	}
+
+	hosts, _, err := db.ListHosts(db.HostFilter{Name: *name, Mac: *mac, Labels: labelFilter}, db.ListOptions{Limit: -1}, database)
+	if err != nil {
+		return err
+	}
+	tasks, err := db.GetTasks(database)
+	if err != nil {
+		return err
+	}
+	taskNames := map[int]string{}
+	for _, task := range tasks {
+		taskNames[task.ID] = task.Name
+	}
+
+	table := newTable()
+	fmt.Fprintln(table, "ID\tMAC\tNAME\tTASK\tPERMANENT\tRESERVED IP\tLABELS")
+	for _, host := range hosts {
+		task, ip, labels := "-", "-", host.LabelString(",")
+		if host.TaskID != nil {
+			task = taskNames[*host.TaskID]
+		}
+		if host.ReservedIP != nil {
+			ip = *host.ReservedIP
+		}
+		if labels == "" {
+			labels = "-"
+		}
+		fmt.Fprintf(table, "%d\t%s\t%s\t%s\t%t\t%s\t%s\n", host.ID, host.Mac, host.Name, task, host.PermanentTask, ip, labels)
+	}
+	return table.Flush()
+}
+
+func hostRm(fs *flag.FlagSet, args []string) error {
+	paths := addPathFlags(fs)
+	if err := parseArgs(fs, args, 1, -1); err != nil {
+		return err
+	}
+
+	database, _, err := paths.openDB(false)
+	if err != nil {
+		return err
+	}
+
+	for _, arg := range fs.Args() {
+		host, err := findHost(arg, database)
+		if err != nil {
+			return err
+		}
+		if err := db.DeleteHost(strconv.FormatUint(uint64(host.ID), 10), database); err != nil {
+			return err
+		}
+		fmt.Printf("Deleted host %s (%s)\n", host.Name, host.Mac)
+	}
+	return nil
+}
+
+func hostSetTask(fs *flag.FlagSet, args []string) error {
+	paths := addPathFlags(fs)
+	permanent := fs.Bool("permanent", false, "keep the task after it has booted")
+	if err := parseArgs(fs, args, 2, 2); err != nil {
+		return err
+	}
+
+	database, _, err := paths.openDB(false)
+	if err != nil {
+		return err
+	}
+
+	host, err := findHost(fs.Arg(0), database)
+	if err != nil {
+		return err
+	}
+
+	var taskID int
+	taskName := "no task"
+	if fs.Arg(1) != "none" {
+		task, err := findTask(fs.Arg(1), database)
+		if err != nil {
+			return err
+		}
+		taskID, taskName = task.ID, "task "+task.Name
+	}
+
+	if err := db.EditHost(host.Name, host.Mac, &taskID, *permanent, host.ID, database); err != nil {
+		return err
+	}
+
+	fmt.Printf("Host %s now has %s\n", host.Name, taskName)
+	return nil
+}
+
+func hostImport(fs *flag.FlagSet, args []string) error {
+	paths := addPathFlags(fs)
+	dryRun := fs.Bool("dry-run", false, "only check the file and show what would change")
+	if err := parseArgs(fs, args, 1, 1); err != nil {
+		return err
+	}
+
+	file, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	database, _, err := paths.openDB(false)
+	if err != nil {
+		return err
+	}
+
+	result, err := db.ImportHostsCSV(file, *dryRun, true, database)
+	if err != nil {
+		return err
+	}
+
+	table := newTable()
+	fmt.Fprintln(table, "LINE\tMAC\tNAME\tRESULT")
+	for _, row := range result.Rows {
+		status := row.Action
+		if len(row.Errors) > 0 {
+			status = "error: " + strings.Join(row.Errors, "; ")
+		}
+		fmt.Fprintf(table, "%d\t%s\t%s\t%s\n", row.Line, row.Mac, row.Name, status)
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+
+	switch {
+	case !result.Valid():
+		return errors.New("some rows are invalid, nothing was imported")
+	case result.DryRun:
+		fmt.Printf("Would create %d and update %d hosts\n", result.Created, result.Updated)
+	default:
+		fmt.Printf("Created %d and updated %d hosts\n", result.Created, result.Updated)
+	}
+	return nil
+}
+
+func hostExport(fs *flag.FlagSet, args []string) error {
+	paths := addPathFlags(fs)
+	if err := parseArgs(fs, args, 0, 0); err != nil {
+		return err
+	}
+
+	database, _, err := paths.openDB(false)
+	if err != nil {
+		return err
+	}
+
+	return db.ExportHostsCSV(os.Stdout, true, database)
+}
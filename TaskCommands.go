@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"pxehub/internal/db"
+
+	"gorm.io/gorm"
+)
+
+// taskFile is a task as written by task export and read by task import.
+type taskFile struct {
+	Name   string `json:"name"`
+	Script string `json:"script"`
+}
+
+// readTaskFile reads the tasks in path. A .json file holds a list of tasks,
+// any other file is the script of one task named after the file.
+func readTaskFile(path string) ([]taskFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.ToLower(filepath.Ext(path)) != ".json" {
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		return []taskFile{{Name: name, Script: string(data)}}, nil
+	}
+
+	var tasks []taskFile
+	if err := json.Unmarshal(data, &tasks); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return tasks, nil
+}
+
+// cliAuthor names who ran a command, for task revisions.
+func cliAuthor() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username + " (command line)"
+	}
+	return "command line"
+}
+
+func taskImport(fs *flag.FlagSet, args []string) error {
+	paths := addPathFlags(fs)
+	comment := fs.String("comment", "", "comment recorded with each changed script's revision")
+	if err := parseArgs(fs, args, 1, -1); err != nil {
+		return err
+	}
+
+	var tasks []taskFile
+	for _, path := range fs.Args() {
+		read, err := readTaskFile(path)
+		if err != nil {
+			return err
+		}
+		tasks = append(tasks, read...)
+	}
+
+	database, _, err := paths.openDB(false)
+	if err != nil {
+		return err
+	}
+
+	// Tasks with the name of an existing task replace its script.
+	return database.Transaction(func(tx *gorm.DB) error {
+		for _, task := range tasks {
+			existing, err := db.GetTaskByName(task.Name, tx)
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				if _, err := db.CreateTask(task.Name, task.Script, cliAuthor(), *comment, tx); err != nil {
+					return fmt.Errorf("task %s: %w", task.Name, err)
+				}
+				fmt.Printf("Created task %s\n", task.Name)
+				continue
+			} else if err != nil {
+				return err
+			}
+
+			if err := db.EditTask(task.Name, task.Script, strconv.Itoa(existing.ID), cliAuthor(), *comment, tx); err != nil {
+				return fmt.Errorf("task %s: %w", task.Name, err)
+			}
+			fmt.Printf("Updated task %s\n", task.Name)
+		}
+		return nil
+	})
+}
+
+func taskExport(fs *flag.FlagSet, args []string) error {
+	paths := addPathFlags(fs)
+	if err := parseArgs(fs, args, 0, -1); err != nil {
+		return err
+	}
+
+	database, _, err := paths.openDB(false)
+	if err != nil {
+		return err
+	}
+
+	tasks, err := db.GetTasks(database)
+	if err != nil {
+		return err
+	}
+
+	out := []taskFile{}
+	for _, task := range tasks {
+		if fs.NArg() == 0 || slices.Contains(fs.Args(), task.Name) {
+			out = append(out, taskFile{Name: task.Name, Script: task.Script})
+		}
+	}
+	if fs.NArg() > len(out) {
+		return fmt.Errorf("found %d of %d tasks", len(out), fs.NArg())
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"pxehub/internal/db"
+
+	"gorm.io/gorm"
+)
+
+func wifiKeyImport(fs *flag.FlagSet, args []string) error {
+	paths := addPathFlags(fs)
+	if err := parseArgs(fs, args, 1, 1); err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	database, _, err := paths.openDB(false)
+	if err != nil {
+		return err
+	}
+
+	// Blank lines and lines starting with # are skipped, and keys that
+	// already exist are left alone, so a file can be imported again.
+	var added, skipped int
+	for _, line := range strings.Split(string(data), "\n") {
+		key := strings.TrimSpace(line)
+		if key == "" || strings.HasPrefix(key, "#") {
+			continue
+		}
+
+		_, err := db.CreateWifiKey(key, database)
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			skipped++
+			continue
+		} else if err != nil {
+			return err
+		}
+		added++
+	}
+
+	fmt.Printf("Added %d wifi keys, %d already existed\n", added, skipped)
+	return nil
+}
+
+func wifiKeyList(fs *flag.FlagSet, args []string) error {
+	paths := addPathFlags(fs)
+	unused := fs.Bool("unused", false, "only keys not assigned to a host")
+	if err := parseArgs(fs, args, 0, 0); err != nil {
+		return err
+	}
+
+	database, _, err := paths.openDB(false)
+	if err != nil {
+		return err
+	}
+
+	var used *bool
+	if *unused {
+		used = new(bool)
+	}
+	keys, _, err := db.ListWifiKeys(used, db.ListOptions{Limit: -1}, database)
+	if err != nil {
+		return err
+	}
+	usedIDs, err := db.GetUsedWifiKeyIDs(database)
+	if err != nil {
+		return err
+	}
+
+	table := newTable()
+	fmt.Fprintln(table, "ID\tKEY\tUSED")
+	for _, key := range keys {
+		fmt.Fprintf(table, "%d\t%s\t%t\n", key.ID, key.Key, usedIDs[key.ID])
+	}
+	return table.Flush()
+}
@@ -4,7 +4,9 @@ go 1.25.1
 
 require (
 	github.com/julienschmidt/httprouter v1.3.0
+	golang.org/x/crypto v0.42.0
 	golang.org/x/text v0.29.0
+	gopkg.in/yaml.v3 v3.0.1
 	gorm.io/driver/sqlite v1.6.0
 	gorm.io/gorm v1.31.0
 )
@@ -0,0 +1,71 @@
+package bootserver
+
+import (
+	"errors"
+
+	"pxehub/internal/netboot"
+)
+
+// group runs several servers as one, such as a built-in server per scope.
+type group []Server
+
+// Start starts every server, stopping those already started if one fails.
+func (g group) Start() error {
+	for i, server := range g {
+		if err := server.Start(); err != nil {
+			for _, started := range g[:i] {
+				started.Stop()
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (g group) Stop() error {
+	var errs []error
+	for _, server := range g {
+		errs = append(errs, server.Stop())
+	}
+	return errors.Join(errs...)
+}
+
+func (g group) Test() error {
+	for _, server := range g {
+		if err := server.Test(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g group) SetReservations(reservations []netboot.Reservation) error {
+	var errs []error
+	for _, server := range g {
+		errs = append(errs, server.SetReservations(reservations))
+	}
+	return errors.Join(errs...)
+}
+
+func (g group) SetLeases(leases []netboot.Lease) error {
+	var errs []error
+	for _, server := range g {
+		errs = append(errs, server.SetLeases(leases))
+	}
+	return errors.Join(errs...)
+}
+
+// Status reports the first server that is not running, or else the first
+// server.
+func (g group) Status() netboot.Status {
+	if len(g) == 0 {
+		return netboot.Status{State: netboot.StateStopped}
+	}
+
+	for _, server := range g {
+		if status := server.Status(); status.State != netboot.StateRunning {
+			return status
+		}
+	}
+	return g[0].Status()
+}
@@ -0,0 +1,286 @@
+package bootserver
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"net"
+	"slices"
+	"sync"
+
+	"pxehub/internal/dnsmasq"
+	"pxehub/internal/ipxe"
+	"pxehub/internal/netboot"
+)
+
+// Server provides DHCP and TFTP to PXE clients.
+type Server interface {
+	Start() error
+	Stop() error
+	// Test checks the configuration without starting the server.
+	Test() error
+	SetReservations([]netboot.Reservation) error
+	// SetLeases gives the server the leases handed out before it started,
+	// so it does not offer their addresses to other clients.
+	SetLeases([]netboot.Lease) error
+	Status() netboot.Status
+}
+
+// Config selects and configures the DHCP and TFTP server.
+type Config struct {
+	Server    string // "dnsmasq" (the default) or "builtin"
+	Scopes    []netboot.Scope
+	TFTPDir   string
+	IPXE      ipxe.Options
+	BootFiles map[uint16]string
+	// Proxy leaves addresses to another DHCP server on each scope's
+	// ProxySubnet and only supplies boot information.
+	Proxy bool
+	// RuntimeDir holds files generated for dnsmasq.
+	RuntimeDir string
+}
+
+// Equal reports whether both configurations describe the same server.
+func (c Config) Equal(other Config) bool {
+	return c.Server == other.Server &&
+		slices.EqualFunc(c.Scopes, other.Scopes, netboot.Scope.Equal) &&
+		c.TFTPDir == other.TFTPDir &&
+		c.IPXE.Offline == other.IPXE.Offline &&
+		c.IPXE.SourceDir == other.IPXE.SourceDir &&
+		maps.Equal(c.IPXE.SHA256, other.IPXE.SHA256) &&
+		maps.Equal(c.BootFiles, other.BootFiles) &&
+		c.Proxy == other.Proxy &&
+		c.RuntimeDir == other.RuntimeDir
+}
+
+// Subnets returns the subnets of the scopes, skipping any that are invalid.
+func (c Config) Subnets() []*net.IPNet {
+	var subnets []*net.IPNet
+	for _, scope := range c.Scopes {
+		if subnet := scope.Subnet(); subnet != nil {
+			subnets = append(subnets, subnet)
+		}
+	}
+	return subnets
+}
+
+// New returns the server selected by cfg.Server.
+func New(cfg Config, onLease func(netboot.Lease)) (Server, error) {
+	switch cfg.Server {
+	case "", "dnsmasq":
+		return &dnsmasq.DnsmasqServer{
+			Scopes:     cfg.Scopes,
+			TFTPDir:    cfg.TFTPDir,
+			IPXE:       cfg.IPXE,
+			BootFiles:  cfg.BootFiles,
+			Proxy:      cfg.Proxy,
+			RuntimeDir: cfg.RuntimeDir,
+			OnLease:    onLease,
+		}, nil
+	case "builtin":
+		if cfg.Proxy {
+			return nil, fmt.Errorf("proxy DHCP mode needs DHCP_SERVER=dnsmasq")
+		}
+
+		var servers group
+		ifaces := map[string]string{}
+		for _, scope := range cfg.Scopes {
+			if other, ok := ifaces[scope.Iface]; ok {
+				return nil, fmt.Errorf("scopes %s and %s share interface %s, which the builtin server does not support",
+					other, scope.Name, scope.Iface)
+			}
+			ifaces[scope.Iface] = scope.Name
+
+			servers = append(servers, &netboot.NetbootServer{
+				Scope:     scope,
+				TFTPDir:   cfg.TFTPDir,
+				IPXE:      cfg.IPXE,
+				BootFiles: cfg.BootFiles,
+				OnLease:   onLease,
+			})
+		}
+		return servers, nil
+	default:
+		return nil, fmt.Errorf("unknown DHCP_SERVER %q, expected dnsmasq or builtin", cfg.Server)
+	}
+}
+
+// Manager runs the DHCP and TFTP server and reloads it when its
+// configuration or reservations change.
+type Manager struct {
+	// Load reads the current configuration.
+	Load func() (Config, error)
+	// Reservations returns the current static reservations.
+	Reservations func() ([]netboot.Reservation, error)
+	// Leases returns the leases that have not expired, as recorded through
+	// OnLease.
+	Leases  func() ([]netboot.Lease, error)
+	OnLease func(netboot.Lease)
+
+	mu      sync.Mutex
+	config  Config
+	server  Server
+	lastErr error
+}
+
+func (m *Manager) applyReservations(server Server) error {
+	if m.Reservations == nil {
+		return nil
+	}
+
+	reservations, err := m.Reservations()
+	if err != nil {
+		return fmt.Errorf("failed to load reservations: %w", err)
+	}
+
+	return server.SetReservations(reservations)
+}
+
+func (m *Manager) applyLeases(server Server) error {
+	if m.Leases == nil {
+		return nil
+	}
+
+	leases, err := m.Leases()
+	if err != nil {
+		return fmt.Errorf("failed to load leases: %w", err)
+	}
+
+	return server.SetLeases(leases)
+}
+
+func (m *Manager) Start() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cfg, err := m.Load()
+	if err != nil {
+		return err
+	}
+
+	m.config = cfg
+
+	server, err := New(cfg, m.OnLease)
+	if err != nil {
+		m.lastErr = err
+		return err
+	}
+
+	if err := m.applyReservations(server); err != nil {
+		m.lastErr = err
+		return err
+	}
+	if err := m.applyLeases(server); err != nil {
+		m.lastErr = err
+		return err
+	}
+
+	if err := server.Start(); err != nil {
+		m.lastErr = err
+		return err
+	}
+
+	m.server, m.lastErr = server, nil
+	return nil
+}
+
+// Status reports the state of the running server. If no server is running
+// because it failed to start, the state is failed.
+func (m *Manager) Status() netboot.Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.server != nil {
+		return m.server.Status()
+	}
+
+	status := netboot.Status{Server: m.config.Server, State: netboot.StateStopped}
+	if status.Server == "" {
+		status.Server = "dnsmasq"
+	}
+	if m.lastErr != nil {
+		status.State = netboot.StateFailed
+		status.LastError = m.lastErr.Error()
+	}
+	return status
+}
+
+// Config returns the configuration of the running server.
+func (m *Manager) Config() Config {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.config
+}
+
+// Reload re-reads the configuration. If it is unchanged, only the
+// reservations are refreshed. Otherwise the new configuration is tested
+// first, and the running server is replaced only if it passes. If the new
+// server fails to start, the old one is started again.
+func (m *Manager) Reload() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cfg, err := m.Load()
+	if err != nil {
+		return err
+	}
+
+	if m.server != nil && cfg.Equal(m.config) {
+		return m.applyReservations(m.server)
+	}
+
+	server, err := New(cfg, m.OnLease)
+	if err != nil {
+		return err
+	}
+	if err := server.Test(); err != nil {
+		return err
+	}
+	if err := m.applyReservations(server); err != nil {
+		return err
+	}
+	if err := m.applyLeases(server); err != nil {
+		return err
+	}
+
+	log.Printf("Reloading dhcp/tftp with new configuration")
+	old := m.server
+	if old != nil {
+		if err := old.Stop(); err != nil {
+			log.Printf("failed to stop dhcp/tftp: %v", err)
+		}
+	}
+
+	if err := server.Start(); err != nil {
+		m.server, m.lastErr = nil, err
+		if old != nil {
+			log.Printf("new dhcp/tftp failed to start, restoring previous configuration: %v", err)
+			if err := m.applyLeases(old); err != nil {
+				log.Printf("failed to restore dhcp/tftp leases: %v", err)
+			}
+			if restoreErr := old.Start(); restoreErr != nil {
+				log.Printf("failed to restore dhcp/tftp: %v", restoreErr)
+			} else {
+				m.server = old
+			}
+		}
+		return err
+	}
+
+	m.config, m.server, m.lastErr = cfg, server, nil
+	return nil
+}
+
+func (m *Manager) Stop() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.server == nil {
+		return nil
+	}
+
+	err := m.server.Stop()
+	m.server = nil
+	return err
+}
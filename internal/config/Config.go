@@ -0,0 +1,206 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix prefixes environment variables that override config keys, e.g.
+// PXEHUB_HTTP_BIND overrides HTTP_BIND.
+const EnvPrefix = "PXEHUB_"
+
+// DefaultDataDir is where pxehub keeps its files unless DATA_DIR is set.
+const DefaultDataDir = "/opt/pxehub"
+
+// FileNames are looked for in the data directory, in order, when no config
+// file is given.
+var FileNames = []string{"pxehub.yaml", "pxehub.yml", "pxehub.conf"}
+
+// Config is the pxehub configuration. It is read from a YAML file, or from
+// the older KEY=VALUE format, in which each key sets one field.
+type Config struct {
+	// DataDir is the base of every path that is not set. The others
+	// default to DataDir/tftp, DataDir/http, DataDir/pxehub.db and
+	// DataDir/run.
+	DataDir    string `yaml:"data_dir"`
+	TFTPDir    string `yaml:"tftp_dir"`
+	ExtrasDir  string `yaml:"extras_dir"`
+	DBPath     string `yaml:"db_path"`
+	RuntimeDir string `yaml:"runtime_dir"`
+
+	HTTPBind   string            `yaml:"http_bind"`
+	DHCPServer string            `yaml:"dhcp_server"` // "dnsmasq" (the default) or "builtin"
+	DHCPMode   string            `yaml:"dhcp_mode"`   // "server" (the default) or "proxy"
+	Scopes     []Scope           `yaml:"scopes"`
+	BootFiles  map[uint16]string `yaml:"boot_files"`
+	IPXE       IPXE              `yaml:"ipxe"`
+	// ScriptVars are custom variables for task scripts, which use them as
+	// {{ .Vars.name }}.
+	ScriptVars map[string]string `yaml:"script_vars"`
+}
+
+// Scope is a DHCP range served on one interface.
+type Scope struct {
+	Name        string   `yaml:"name"`
+	Interface   string   `yaml:"interface"`
+	RangeStart  string   `yaml:"range_start"`
+	RangeEnd    string   `yaml:"range_end"`
+	Mask        string   `yaml:"mask"`
+	Router      string   `yaml:"router"`
+	DNSServers  []string `yaml:"dns_servers"`
+	ProxySubnet string   `yaml:"proxy_subnet"`
+	DefaultTask string   `yaml:"default_task"`
+}
+
+// IPXE controls where the iPXE binaries come from.
+type IPXE struct {
+	Offline bool              `yaml:"offline"`
+	Dir     string            `yaml:"dir"`
+	SHA256  map[string]string `yaml:"sha256"`
+}
+
+// Proxy reports whether pxehub runs as a proxy DHCP server.
+func (c *Config) Proxy() bool {
+	return c.DHCPMode == "proxy"
+}
+
+// FindPath returns path if it is set, or else the first of FileNames that
+// exists in dataDir, which defaults to DefaultDataDir.
+func FindPath(path, dataDir string) (string, error) {
+	if path != "" {
+		return path, nil
+	}
+	if dataDir == "" {
+		dataDir = DefaultDataDir
+	}
+
+	var tried []string
+	for _, name := range FileNames {
+		p := filepath.Join(dataDir, name)
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+		tried = append(tried, p)
+	}
+
+	return "", fmt.Errorf("no config file found, tried %s", strings.Join(tried, ", "))
+}
+
+// setDefaults fills in the paths that are not set.
+func (c *Config) setDefaults() {
+	if c.DataDir == "" {
+		c.DataDir = DefaultDataDir
+	}
+
+	defaults := []struct {
+		path *string
+		name string
+	}{
+		{&c.TFTPDir, "tftp"},
+		{&c.ExtrasDir, "http"},
+		{&c.DBPath, "pxehub.db"},
+		{&c.RuntimeDir, "run"},
+	}
+	for _, d := range defaults {
+		if *d.path == "" {
+			*d.path = filepath.Join(c.DataDir, d.name)
+		}
+	}
+}
+
+// Load reads the config file at path, applies PXEHUB_* environment
+// overrides and then overrides, which use the same keys, and validates the
+// result. Files ending in .yaml or .yml are YAML, anything else is
+// KEY=VALUE, except that TOML files are refused rather than misread.
+func Load(path string, overrides map[string]string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	case ".toml":
+		return nil, fmt.Errorf("%s: TOML is not supported, use YAML or KEY=VALUE", path)
+	default:
+		keys, err := parseKeyValues(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if err := cfg.apply(keys); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	if err := cfg.apply(envKeys()); err != nil {
+		return nil, fmt.Errorf("environment: %w", err)
+	}
+	if err := cfg.apply(overrides); err != nil {
+		return nil, err
+	}
+	cfg.setDefaults()
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// parseKeyValues parses KEY=VALUE lines. Blank lines and lines starting with
+// # are skipped.
+func parseKeyValues(data []byte) (map[string]string, error) {
+	keys := map[string]string{}
+
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", i+1)
+		}
+		keys[key] = strings.TrimSpace(value)
+	}
+
+	return keys, nil
+}
+
+// envKeys returns the config keys set by PXEHUB_* environment variables.
+// Variables that are not config keys are skipped with a warning, since
+// they may well be meant for something else.
+func envKeys() map[string]string {
+	keys := map[string]string{}
+
+	for _, env := range os.Environ() {
+		name, value, _ := strings.Cut(env, "=")
+		key, ok := strings.CutPrefix(name, EnvPrefix)
+		if !ok || key == "CONFIG" {
+			continue
+		}
+		if !isKey(key) {
+			log.Printf("ignoring %s, which is not a config key", name)
+			continue
+		}
+		keys[key] = value
+	}
+
+	return keys
+}
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testKeyValues = `HTTP_BIND=127.0.0.1:8080
+INTERFACE=lo
+DHCP_RANGE_START=127.0.0.10
+DHCP_RANGE_END=127.0.0.20
+DHCP_MASK=255.0.0.0
+`
+
+func writeConfig(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadEnvironment(t *testing.T) {
+	path := writeConfig(t, "pxehub.conf", testKeyValues)
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+		err  string
+	}{
+		{"no overrides", nil, "127.0.0.1:8080", ""},
+		{"override", map[string]string{"PXEHUB_HTTP_BIND": "127.0.0.1:9090"}, "127.0.0.1:9090", ""},
+		{"stray variable", map[string]string{"PXEHUB_BUILD_ID": "42"}, "127.0.0.1:8080", ""},
+		{"scope key", map[string]string{"PXEHUB_SCOPE_DEFAULT_DHCP_ROUTER": "127.0.0.1"}, "127.0.0.1:8080", ""},
+		{"bad value", map[string]string{"PXEHUB_IPXE_OFFLINE": "maybe"}, "", "IPXE_OFFLINE"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			cfg, err := Load(path, nil)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got %v, want an error containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cfg.HTTPBind != tt.want {
+				t.Fatalf("got http bind %q, want %q", cfg.HTTPBind, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadFormats(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		data string
+		err  string
+	}{
+		{"key value", "pxehub.conf", testKeyValues, ""},
+		{"unknown key in file", "pxehub.conf", testKeyValues + "HTTP_BINDS=x\n", "unknown key"},
+		{"not key value", "pxehub.conf", testKeyValues + "oops\n", "line 6"},
+		{"yaml", "pxehub.yaml", `http_bind: 127.0.0.1:8080
+scopes:
+  - name: default
+    interface: lo
+    range_start: 127.0.0.10
+    range_end: 127.0.0.20
+    mask: 255.0.0.0
+`, ""},
+		{"unknown yaml field", "pxehub.yml", "http_bind: 127.0.0.1:8080\nhttp_binds: x\n", "http_binds"},
+		{"toml", "pxehub.toml", "http_bind = \"127.0.0.1:8080\"\n", "TOML is not supported"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeConfig(t, tt.file, tt.data), nil)
+			if tt.err == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			} else if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("got %v, want an error containing %q", err, tt.err)
+			}
+		})
+	}
+}
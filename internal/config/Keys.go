@@ -0,0 +1,181 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// scopeKeys set the fields of a scope. With several scopes they are
+// prefixed with SCOPE_<NAME>_.
+var scopeKeys = map[string]func(s *Scope, value string) error{
+	"INTERFACE":         func(s *Scope, v string) error { s.Interface = v; return nil },
+	"DHCP_RANGE_START":  func(s *Scope, v string) error { s.RangeStart = v; return nil },
+	"DHCP_RANGE_END":    func(s *Scope, v string) error { s.RangeEnd = v; return nil },
+	"DHCP_MASK":         func(s *Scope, v string) error { s.Mask = v; return nil },
+	"DHCP_ROUTER":       func(s *Scope, v string) error { s.Router = v; return nil },
+	"DNS_SERVERS":       func(s *Scope, v string) error { s.DNSServers = splitList(v); return nil },
+	"DHCP_PROXY_SUBNET": func(s *Scope, v string) error { s.ProxySubnet = v; return nil },
+	"DEFAULT_TASK":      func(s *Scope, v string) error { s.DefaultTask = v; return nil },
+}
+
+// globalKeys set the remaining fields.
+var globalKeys = map[string]func(c *Config, value string) error{
+	"DATA_DIR":    func(c *Config, v string) error { c.DataDir = v; return nil },
+	"TFTP_DIR":    func(c *Config, v string) error { c.TFTPDir = v; return nil },
+	"EXTRAS_DIR":  func(c *Config, v string) error { c.ExtrasDir = v; return nil },
+	"DB_PATH":     func(c *Config, v string) error { c.DBPath = v; return nil },
+	"RUNTIME_DIR": func(c *Config, v string) error { c.RuntimeDir = v; return nil },
+	"HTTP_BIND":   func(c *Config, v string) error { c.HTTPBind = v; return nil },
+	"DHCP_SERVER": func(c *Config, v string) error { c.DHCPServer = v; return nil },
+	"DHCP_MODE":   func(c *Config, v string) error { c.DHCPMode = v; return nil },
+	"IPXE_DIR":    func(c *Config, v string) error { c.IPXE.Dir = v; return nil },
+	"IPXE_OFFLINE": func(c *Config, v string) error {
+		offline, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("expected true or false, got %q", v)
+		}
+		c.IPXE.Offline = offline
+		return nil
+	},
+	"IPXE_SHA256": func(c *Config, v string) error {
+		c.IPXE.SHA256 = map[string]string{}
+		for _, pin := range splitList(v) {
+			name, digest, ok := strings.Cut(pin, ":")
+			if !ok {
+				return fmt.Errorf("expected name:sha256, got %q", pin)
+			}
+			c.IPXE.SHA256[strings.TrimSpace(name)] = strings.TrimSpace(digest)
+		}
+		return nil
+	},
+	"SCRIPT_VARS": func(c *Config, v string) error {
+		c.ScriptVars = map[string]string{}
+		for _, entry := range splitList(v) {
+			name, value, ok := strings.Cut(entry, "=")
+			if !ok {
+				return fmt.Errorf("expected name=value, got %q", entry)
+			}
+			c.ScriptVars[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+		return nil
+	},
+	"BOOT_FILES": func(c *Config, v string) error {
+		c.BootFiles = map[uint16]string{}
+		for _, entry := range splitList(v) {
+			archStr, file, _ := strings.Cut(entry, ":")
+			arch, err := strconv.ParseUint(strings.TrimSpace(archStr), 10, 16)
+			if err != nil {
+				return fmt.Errorf("expected arch:file, got %q", entry)
+			}
+			c.BootFiles[uint16(arch)] = strings.TrimSpace(file)
+		}
+		return nil
+	},
+}
+
+// splitList splits a comma separated list, dropping empty items.
+func splitList(val string) []string {
+	items := []string{}
+	for _, item := range strings.Split(val, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// isKey reports whether key names a setting, whether or not the scope it
+// is for exists.
+func isKey(key string) bool {
+	if _, ok := globalKeys[key]; ok || key == "SCOPES" {
+		return true
+	}
+	if _, ok := scopeKeys[key]; ok {
+		return true
+	}
+	if rest, ok := strings.CutPrefix(key, "SCOPE_"); ok {
+		for field := range scopeKeys {
+			if name, ok := strings.CutSuffix(rest, "_"+field); ok && name != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// keyName returns the SCOPE_<NAME>_ form of a scope name.
+func keyName(scope string) string {
+	return strings.ToUpper(strings.ReplaceAll(scope, "-", "_"))
+}
+
+// apply sets the fields named by KEY=VALUE style keys. SCOPES lists the
+// scopes by name, keeping the settings of those that already exist.
+// Unprefixed scope keys apply to the only scope, which is created as
+// "default" if there is none.
+func (c *Config) apply(keys map[string]string) error {
+	if val, ok := keys["SCOPES"]; ok {
+		var scopes []Scope
+		for _, name := range splitList(val) {
+			i := slices.IndexFunc(c.Scopes, func(s Scope) bool { return s.Name == name })
+			if i >= 0 {
+				scopes = append(scopes, c.Scopes[i])
+			} else {
+				scopes = append(scopes, Scope{Name: name})
+			}
+		}
+		c.Scopes = scopes
+	}
+
+	names := make([]string, 0, len(keys))
+	for key := range keys {
+		names = append(names, key)
+	}
+	slices.Sort(names)
+
+	for _, key := range names {
+		if key == "SCOPES" {
+			continue
+		}
+		if err := c.set(key, keys[key]); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) set(key, value string) error {
+	if set, ok := globalKeys[key]; ok {
+		return set(c, value)
+	}
+
+	if set, ok := scopeKeys[key]; ok {
+		switch len(c.Scopes) {
+		case 0:
+			c.Scopes = []Scope{{Name: "default"}}
+		case 1:
+		default:
+			return fmt.Errorf("there are several scopes, use SCOPE_<NAME>_%s", key)
+		}
+		return set(&c.Scopes[0], value)
+	}
+
+	if rest, ok := strings.CutPrefix(key, "SCOPE_"); ok {
+		for field, set := range scopeKeys {
+			name, ok := strings.CutSuffix(rest, "_"+field)
+			if !ok {
+				continue
+			}
+			for i := range c.Scopes {
+				if keyName(c.Scopes[i].Name) == name {
+					return set(&c.Scopes[i], value)
+				}
+			}
+			return fmt.Errorf("no scope named %s in SCOPES", name)
+		}
+	}
+
+	return fmt.Errorf("unknown key")
+}
@@ -0,0 +1,189 @@
+package config
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+var scopeNameRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+var scriptVarRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// fieldError names the config field that is wrong.
+type fieldError struct {
+	Field string
+	Err   error
+}
+
+func (e *fieldError) Error() string {
+	return e.Field + ": " + e.Err.Error()
+}
+
+func (e *fieldError) Unwrap() error {
+	return e.Err
+}
+
+// validator collects every error, so all of them can be fixed at once.
+type validator struct {
+	errs []error
+}
+
+func (v *validator) fail(field, format string, args ...any) {
+	v.errs = append(v.errs, &fieldError{Field: field, Err: fmt.Errorf(format, args...)})
+}
+
+// ipv4 parses an IPv4 address, recording an error if it is invalid.
+// Optional fields may be empty.
+func (v *validator) ipv4(field, value string, optional bool) net.IP {
+	if value == "" {
+		if !optional {
+			v.fail(field, "is required")
+		}
+		return nil
+	}
+
+	ip := net.ParseIP(value).To4()
+	if ip == nil {
+		v.fail(field, "%q is not an IPv4 address", value)
+	}
+	return ip
+}
+
+// Validate checks that the configuration is complete and consistent, and
+// that the interfaces it names exist. The error lists every problem found.
+func (c *Config) Validate() error {
+	v := &validator{}
+
+	if c.HTTPBind == "" {
+		v.fail("http_bind", "is required")
+	} else if host, port, err := net.SplitHostPort(c.HTTPBind); err != nil {
+		v.fail("http_bind", "%q is not host:port", c.HTTPBind)
+	} else {
+		if n, err := strconv.ParseUint(port, 10, 16); err != nil || n == 0 {
+			v.fail("http_bind", "invalid port %q", port)
+		}
+		if host != "" && net.ParseIP(host) == nil {
+			v.fail("http_bind", "%q is not an IP address", host)
+		}
+	}
+
+	switch c.DHCPServer {
+	case "", "dnsmasq", "builtin":
+	default:
+		v.fail("dhcp_server", "unknown server %q, expected dnsmasq or builtin", c.DHCPServer)
+	}
+
+	switch c.DHCPMode {
+	case "", "server":
+	case "proxy":
+		if c.DHCPServer == "builtin" {
+			v.fail("dhcp_mode", "proxy mode needs dhcp_server dnsmasq")
+		}
+	default:
+		v.fail("dhcp_mode", "unknown mode %q, expected server or proxy", c.DHCPMode)
+	}
+
+	if len(c.Scopes) == 0 {
+		v.fail("scopes", "at least one scope is required")
+	}
+
+	names := map[string]bool{}
+	ifaces := map[string]string{}
+	for i, scope := range c.Scopes {
+		field := fmt.Sprintf("scopes[%d]", i)
+		if scope.Name != "" {
+			field = fmt.Sprintf("scopes[%s]", scope.Name)
+		}
+
+		if !scopeNameRegex.MatchString(scope.Name) {
+			v.fail(field+".name", "%q must be letters, digits, - or _", scope.Name)
+		} else if names[keyName(scope.Name)] {
+			v.fail(field+".name", "duplicate scope")
+		}
+		names[keyName(scope.Name)] = true
+
+		c.validateScope(v, field, scope)
+
+		if other, ok := ifaces[scope.Interface]; ok && c.DHCPServer == "builtin" {
+			v.fail(field+".interface", "%s is also used by scope %s, which the builtin server does not support", scope.Interface, other)
+		}
+		ifaces[scope.Interface] = scope.Name
+	}
+
+	for arch, file := range c.BootFiles {
+		if !filepath.IsLocal(file) {
+			v.fail(fmt.Sprintf("boot_files[%d]", arch), "%q must be a relative path inside the TFTP directory", file)
+		}
+	}
+
+	for name := range c.ScriptVars {
+		if !scriptVarRegex.MatchString(name) {
+			v.fail(fmt.Sprintf("script_vars[%s]", name), "name must be letters, digits or _, not starting with a digit")
+		}
+	}
+
+	for name, digest := range c.IPXE.SHA256 {
+		if b, err := hex.DecodeString(digest); err != nil || len(b) != 32 {
+			v.fail(fmt.Sprintf("ipxe.sha256[%s]", name), "%q is not a SHA256 hex digest", digest)
+		}
+	}
+
+	return errors.Join(v.errs...)
+}
+
+func (c *Config) validateScope(v *validator, field string, scope Scope) {
+	if scope.Interface == "" {
+		v.fail(field+".interface", "is required")
+	} else if _, err := net.InterfaceByName(scope.Interface); err != nil {
+		v.fail(field+".interface", "%s: %v", scope.Interface, err)
+	}
+
+	maskIP := v.ipv4(field+".mask", scope.Mask, false)
+	var mask net.IPMask
+	if maskIP != nil {
+		mask = net.IPMask(maskIP)
+		if ones, bits := mask.Size(); ones == 0 && bits == 0 {
+			v.fail(field+".mask", "%s is not a valid subnet mask", scope.Mask)
+			mask = nil
+		}
+	}
+
+	if c.Proxy() {
+		subnet := v.ipv4(field+".proxy_subnet", scope.ProxySubnet, false)
+		if subnet != nil && mask != nil && !subnet.Equal(subnet.Mask(mask)) {
+			v.fail(field+".proxy_subnet", "%s is not the network address of a /%d subnet", scope.ProxySubnet, maskBits(mask))
+		}
+		return
+	}
+
+	start := v.ipv4(field+".range_start", scope.RangeStart, false)
+	end := v.ipv4(field+".range_end", scope.RangeEnd, false)
+	router := v.ipv4(field+".router", scope.Router, true)
+	for j, ns := range scope.DNSServers {
+		v.ipv4(fmt.Sprintf("%s.dns_servers[%d]", field, j), ns, false)
+	}
+
+	if start == nil || end == nil || mask == nil {
+		return
+	}
+
+	subnet := &net.IPNet{IP: start.Mask(mask), Mask: mask}
+	if !subnet.Contains(end) {
+		v.fail(field+".range_end", "%s is outside %s", scope.RangeEnd, subnet)
+	} else if bytes.Compare(start, end) > 0 {
+		v.fail(field+".range_end", "%s is before range_start %s", scope.RangeEnd, scope.RangeStart)
+	}
+	if router != nil && !subnet.Contains(router) {
+		v.fail(field+".router", "%s is outside %s", scope.Router, subnet)
+	}
+}
+
+func maskBits(mask net.IPMask) int {
+	ones, _ := mask.Size()
+	return ones
+}
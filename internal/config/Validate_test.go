@@ -0,0 +1,110 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func validConfig() *Config {
+	return &Config{
+		HTTPBind: "127.0.0.1:8080",
+		Scopes: []Scope{{
+			Name:       "default",
+			Interface:  "lo",
+			RangeStart: "127.0.0.10",
+			RangeEnd:   "127.0.0.20",
+			Mask:       "255.0.0.0",
+		}},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		// errs are the start of each error Validate should report.
+		errs []string
+	}{
+		{"valid", func(c *Config) {}, nil},
+		{"valid with everything", func(c *Config) {
+			c.DHCPServer = "builtin"
+			c.Scopes[0].Router = "127.0.0.1"
+			c.Scopes[0].DNSServers = []string{"127.0.0.1", "1.1.1.1"}
+			c.BootFiles = map[uint16]string{7: "efi/ipxe.efi"}
+			c.ScriptVars = map[string]string{"mirror_1": "x"}
+			c.IPXE.SHA256 = map[string]string{"ipxe.efi": strings.Repeat("ab", 32)}
+		}, nil},
+		{"no http bind", func(c *Config) { c.HTTPBind = "" }, []string{"http_bind: is required"}},
+		{"http bind without port", func(c *Config) { c.HTTPBind = "127.0.0.1" }, []string{"http_bind: \"127.0.0.1\" is not host:port"}},
+		{"http bind bad port", func(c *Config) { c.HTTPBind = ":0" }, []string{"http_bind: invalid port"}},
+		{"http bind hostname", func(c *Config) { c.HTTPBind = "pxehub:80" }, []string{"http_bind: \"pxehub\" is not an IP address"}},
+		{"unknown server", func(c *Config) { c.DHCPServer = "isc" }, []string{"dhcp_server: unknown server"}},
+		{"unknown mode", func(c *Config) { c.DHCPMode = "relay" }, []string{"dhcp_mode: unknown mode"}},
+		{"proxy with builtin", func(c *Config) {
+			c.DHCPServer = "builtin"
+			c.DHCPMode = "proxy"
+			c.Scopes[0].ProxySubnet = "127.0.0.0"
+		}, []string{"dhcp_mode: proxy mode needs dhcp_server dnsmasq"}},
+		{"proxy", func(c *Config) {
+			c.DHCPMode = "proxy"
+			c.Scopes[0] = Scope{Name: "default", Interface: "lo", Mask: "255.0.0.0", ProxySubnet: "127.0.0.0"}
+		}, nil},
+		{"proxy subnet not network address", func(c *Config) {
+			c.DHCPMode = "proxy"
+			c.Scopes[0].ProxySubnet = "127.0.0.1"
+		}, []string{"scopes[default].proxy_subnet: 127.0.0.1 is not the network address of a /8 subnet"}},
+		{"no scopes", func(c *Config) { c.Scopes = nil }, []string{"scopes: at least one scope is required"}},
+		{"bad scope name", func(c *Config) { c.Scopes[0].Name = "a b" }, []string{"scopes[a b].name:"}},
+		{"unnamed scope", func(c *Config) { c.Scopes[0].Name = "" }, []string{"scopes[0].name:"}},
+		{"duplicate scope", func(c *Config) {
+			c.Scopes = append(c.Scopes, c.Scopes[0])
+		}, []string{"scopes[default].name: duplicate scope"}},
+		{"shared interface with builtin", func(c *Config) {
+			c.DHCPServer = "builtin"
+			other := c.Scopes[0]
+			other.Name = "other"
+			c.Scopes = append(c.Scopes, other)
+		}, []string{"scopes[other].interface: lo is also used by scope default"}},
+		{"no interface", func(c *Config) { c.Scopes[0].Interface = "" }, []string{"scopes[default].interface: is required"}},
+		{"missing interface", func(c *Config) { c.Scopes[0].Interface = "nonexistent0" }, []string{"scopes[default].interface: nonexistent0:"}},
+		{"no range", func(c *Config) {
+			c.Scopes[0].RangeStart = ""
+			c.Scopes[0].RangeEnd = ""
+		}, []string{"scopes[default].range_start: is required", "scopes[default].range_end: is required"}},
+		{"bad mask", func(c *Config) { c.Scopes[0].Mask = "255.0.255.0" }, []string{"scopes[default].mask: 255.0.255.0 is not a valid subnet mask"}},
+		{"ipv6 range", func(c *Config) { c.Scopes[0].RangeStart = "::1" }, []string{"scopes[default].range_start: \"::1\" is not an IPv4 address"}},
+		{"range outside subnet", func(c *Config) { c.Scopes[0].RangeEnd = "10.0.0.20" }, []string{"scopes[default].range_end: 10.0.0.20 is outside 127.0.0.0/8"}},
+		{"range backwards", func(c *Config) { c.Scopes[0].RangeEnd = "127.0.0.5" }, []string{"scopes[default].range_end: 127.0.0.5 is before range_start"}},
+		{"router outside subnet", func(c *Config) { c.Scopes[0].Router = "10.0.0.1" }, []string{"scopes[default].router: 10.0.0.1 is outside"}},
+		{"bad dns server", func(c *Config) { c.Scopes[0].DNSServers = []string{"1.1.1.1", "dns"} }, []string{"scopes[default].dns_servers[1]:"}},
+		{"boot file outside tftp", func(c *Config) { c.BootFiles = map[uint16]string{7: "../ipxe.efi"} }, []string{"boot_files[7]:"}},
+		{"bad script var", func(c *Config) { c.ScriptVars = map[string]string{"1st": "x"} }, []string{"script_vars[1st]:"}},
+		{"bad digest", func(c *Config) { c.IPXE.SHA256 = map[string]string{"ipxe.efi": "abc"} }, []string{"ipxe.sha256[ipxe.efi]:"}},
+		{"every error reported", func(c *Config) {
+			c.HTTPBind = ""
+			c.DHCPServer = "isc"
+			c.Scopes[0].Mask = ""
+		}, []string{"http_bind: is required", "dhcp_server: unknown server", "scopes[default].mask: is required"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			tt.change(cfg)
+
+			err := cfg.Validate()
+			var got []string
+			if err != nil {
+				got = strings.Split(err.Error(), "\n")
+			}
+			if len(got) != len(tt.errs) {
+				t.Fatalf("got errors %q, want %q", got, tt.errs)
+			}
+			for i, want := range tt.errs {
+				if !strings.HasPrefix(got[i], want) {
+					t.Errorf("error %d is %q, want it to start with %q", i, got[i], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	ScopeHosts    = "hosts"
+	ScopeTasks    = "tasks"
+	ScopeWifiKeys = "wifikeys"
+	ScopeDHCP     = "dhcp"
+	ScopeReadOnly = "read-only"
+)
+
+var Scopes = []string{ScopeHosts, ScopeTasks, ScopeWifiKeys, ScopeDHCP, ScopeReadOnly}
+
+type APIToken struct {
+	gorm.Model
+	Name       string
+	TokenHash  string `gorm:"uniqueIndex"`
+	Scope      string
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	UserID     uint
+	User       User
+}
+
+var ErrInvalidScope = errors.New("invalid scope")
+var ErrTokenExpired = errors.New("token expired")
+var ErrTokenNoUser = errors.New("token's user no longer exists")
+
+// Allows reports whether the token may call a route in scope with method.
+// Read-only tokens may make GET requests against any scope but wifi keys,
+// which only a wifikeys token can read.
+func (t *APIToken) Allows(scope, method string) bool {
+	if t.Scope == ScopeReadOnly {
+		return scope != ScopeWifiKeys && (method == "GET" || method == "HEAD")
+	}
+	return t.Scope == scope
+}
+
+func (t *APIToken) Expired() bool {
+	return t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt)
+}
+
+// CreateAPIToken stores a new token and returns its plain text value, which
+// is not recoverable afterwards.
+func CreateAPIToken(name, scope string, expiresAt *time.Time, userID uint, db *gorm.DB) (string, error) {
+	if name == "" {
+		return "", errors.New("token name cannot be empty")
+	}
+	if !slices.Contains(Scopes, scope) {
+		return "", ErrInvalidScope
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+
+	ctx := context.Background()
+
+	err = gorm.G[APIToken](db).Create(ctx, &APIToken{
+		Name:      name,
+		TokenHash: hashToken(token),
+		Scope:     scope,
+		ExpiresAt: expiresAt,
+		UserID:    userID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func DeleteAPIToken(id string, db *gorm.DB) error {
+	ctx := context.Background()
+
+	_, err := gorm.G[APIToken](db).Where("id = ?", id).Delete(ctx)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func GetAPITokens(db *gorm.DB) ([]APIToken, error) {
+	ctx := context.Background()
+
+	tokens, err := gorm.G[APIToken](db).Preload("User", nil).Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// UseAPIToken looks up a token by its plain text value, rejects it if it has
+// expired or the user who created it is gone, and records the time it was
+// used. The token's User is loaded, so callers can check the user's role.
+func UseAPIToken(token string, db *gorm.DB) (*APIToken, error) {
+	ctx := context.Background()
+
+	apiToken, err := gorm.G[APIToken](db).Where("token_hash = ?", hashToken(token)).Preload("User", nil).First(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if apiToken.Expired() {
+		return nil, ErrTokenExpired
+	}
+	if apiToken.User.ID == 0 {
+		return nil, ErrTokenNoUser
+	}
+
+	now := time.Now()
+	_, err = gorm.G[APIToken](db).Where("id = ?", apiToken.ID).Update(ctx, "last_used_at", now)
+	if err != nil {
+		return nil, err
+	}
+	apiToken.LastUsedAt = &now
+
+	return &apiToken, nil
+}
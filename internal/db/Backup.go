@@ -0,0 +1,21 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"gorm.io/gorm"
+)
+
+// Backup writes a consistent copy of the database to path, which must not
+// exist yet. It is safe to run while the server is using the database.
+func Backup(path string, db *gorm.DB) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists", path)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return db.Exec("VACUUM INTO ?", path).Error
+}
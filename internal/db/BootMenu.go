@@ -0,0 +1,154 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"log"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// BootMenu is a boot menu changed from its built-in version. A menu with
+// no row uses the built-in one.
+type BootMenu struct {
+	Name      string `gorm:"primaryKey"`
+	Script    string `gorm:"type:longtext"`
+	UpdatedBy string
+	UpdatedAt time.Time
+}
+
+const (
+	MenuUnregistered = "unregistered"
+	MenuRegistered   = "registered"
+)
+
+// Menus are the boot menus: the unregistered menu for hosts that are not
+// registered, and the registered menu for hosts without a task.
+var Menus = []string{MenuUnregistered, MenuRegistered}
+
+var ErrUnknownMenu = errors.New("unknown boot menu")
+
+var builtinMenus = map[string]string{
+	MenuUnregistered: `#!ipxe
+
+menu Boot Menu - Unregistered ({{ .MAC }})
+item --gap -- -------------------------------
+item exit       Exit iPXE
+item register   Register Device
+item netbootxyz netboot.xyz
+choose target && goto ${target}
+
+:register
+echo -n Hostname:
+read hostname
+chain --autofree http://${next-server}/api/new/host/${net0/mac}/${hostname}
+
+:netbootxyz
+chain --autofree http://boot.netboot.xyz/
+
+:exit
+exit
+`,
+	MenuRegistered: `#!ipxe
+
+menu Boot Menu - Registered as {{ .Hostname }}
+item --gap -- -------------------------------
+item local      Boot from local disk
+item exit       Exit iPXE
+item netbootxyz netboot.xyz
+choose --default local --timeout 3000 target || goto local
+goto ${target}
+
+:register
+read hostname
+chain --autofree http://${next-server}/api/new/host/${net0/mac}/${hostname}
+
+:netbootxyz
+chain --autofree http://boot.netboot.xyz/
+
+:local
+:exit
+exit
+`,
+}
+
+// BuiltinMenu returns the built-in version of a boot menu.
+func BuiltinMenu(name string) (string, error) {
+	script, ok := builtinMenus[name]
+	if !ok {
+		return "", ErrUnknownMenu
+	}
+	return script, nil
+}
+
+// GetBootMenu returns a boot menu and whether it has been changed. An
+// unchanged menu has the built-in script and no UpdatedBy.
+func GetBootMenu(name string, db *gorm.DB) (*BootMenu, bool, error) {
+	builtin, err := BuiltinMenu(name)
+	if err != nil {
+		return nil, false, err
+	}
+
+	ctx := context.Background()
+
+	menu, err := gorm.G[BootMenu](db).Where("name = ?", name).First(ctx)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &BootMenu{Name: name, Script: builtin}, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	return &menu, true, nil
+}
+
+// SetBootMenu replaces a boot menu's script. Like a task script, it must
+// pass ValidateScript.
+func SetBootMenu(name, script, author string, db *gorm.DB) error {
+	if !slices.Contains(Menus, name) {
+		return ErrUnknownMenu
+	}
+	if err := ValidateScript(script); err != nil {
+		return err
+	}
+
+	return db.Save(&BootMenu{Name: name, Script: script, UpdatedBy: author}).Error
+}
+
+// ResetBootMenu puts a boot menu back to its built-in version.
+func ResetBootMenu(name string, db *gorm.DB) error {
+	if !slices.Contains(Menus, name) {
+		return ErrUnknownMenu
+	}
+
+	ctx := context.Background()
+
+	_, err := gorm.G[BootMenu](db).Where("name = ?", name).Delete(ctx)
+	return err
+}
+
+// renderMenu renders a boot menu. A changed menu that fails to render is
+// replaced by the built-in one, so hosts are not left without a way to
+// register or boot. The error is passed to onFallback, or logged if it is
+// nil.
+func renderMenu(name string, vars ScriptVars, onFallback func(error), db *gorm.DB) (string, error) {
+	menu, custom, err := GetBootMenu(name, db)
+	if err != nil {
+		return "", err
+	}
+
+	script, err := RenderScript(menu.Script, vars)
+	if err != nil && custom {
+		if onFallback != nil {
+			onFallback(err)
+		} else {
+			log.Printf("%s boot menu failed, using the built-in one: %v", name, err)
+		}
+		return RenderScript(builtinMenus[name], vars)
+	} else if err != nil {
+		return "", err
+	}
+
+	return script, nil
+}
@@ -3,84 +3,144 @@ package db
 import (
 	"context"
 	"errors"
+	"fmt"
+	"regexp"
 	"strings"
 	"time"
 
 	"gorm.io/gorm"
 )
 
-var unregisteredScript = `#!ipxe
-
-menu Boot Menu - Unregistered ({mac})
-item --gap -- -------------------------------
-item exit       Exit iPXE
-item register   Register Device
-item netbootxyz netboot.xyz
-choose target && goto ${target}
-
-:register
-echo -n Hostname:
-read hostname
-chain --autofree http://${next-server}/api/new/host/${net0/mac}/${hostname}
-
-:netbootxyz
-chain --autofree http://boot.netboot.xyz/
-
-:exit
-exit
-`
-
-var registeredScript = `#!ipxe
-
-menu Boot Menu - Registered as {hostname}
-item --gap -- -------------------------------
-item exit       Exit iPXE
-item netbootxyz netboot.xyz
-choose --default local --timeout 3000 target || goto local
-goto ${target}
-
-:register
-read hostname
-chain --autofree http://${next-server}/api/new-host/${net0/mac}/${hostname}
-
-:netbootxyz
-chain --autofree http://boot.netboot.xyz/
-
-:exit
-exit
-`
+// labelPlaceholder is replaced in task scripts by the value of the host's
+// label, or nothing if the host does not have it. Like {hostname}, it
+// predates script templates and is still replaced after rendering.
+var labelPlaceholder = regexp.MustCompile(`\{label:([A-Za-z0-9_.-]+)\}`)
+
+// BootRequest is a request for a host's boot script.
+type BootRequest struct {
+	MAC string
+	// Scope is the DHCP scope the request came from, and DefaultTask the
+	// name of the task booted by registered hosts without one, if any.
+	Scope       string
+	DefaultTask string
+	// Server is the host and port the client reached pxehub on, and
+	// ClientIP the address the request came from.
+	Server   string
+	ClientIP string
+	// Vars are the custom script variables.
+	Vars map[string]string
+	// Preview renders the script without recording the request or using up
+	// a one-off task, leaving the host as it was.
+	Preview bool
+	// OnMenuFallback, if set, is called with the error of a changed boot
+	// menu that failed to render and was replaced by the built-in one.
+	// Otherwise the error is logged.
+	OnMenuFallback func(error)
+}
 
-func GetScriptByMAC(mac string, db *gorm.DB, log bool) (string, error) {
+// GetScriptByMAC returns the boot script for a host. Task scripts and menus
+// are rendered as templates, and a script that fails to render returns an
+// error wrapping ErrInvalidScript.
+func GetScriptByMAC(req BootRequest, db *gorm.DB) (string, error) {
 	ctx := context.Background()
 
-	mac = strings.ToLower(mac)
-	host, err := gorm.G[Host](db).Where("LOWER(mac) = LOWER(?)", mac).First(ctx)
+	mac := strings.ToLower(req.MAC)
+	vars := ScriptVars{MAC: mac, IP: req.ClientIP, Scope: req.Scope, Server: req.Server, Vars: req.Vars}
+	if req.Server != "" {
+		vars.WifiKeyURL = "http://" + req.Server + "/api/get/wifikey/" + mac
+	}
+
+	host, err := gorm.G[Host](db).Where("LOWER(mac) = LOWER(?)", mac).Preload("Labels", nil).First(ctx)
 	if errors.Is(err, gorm.ErrRecordNotFound) {
-		if log {
-			LogRequest(false, time.Now(), mac, db)
+		if !req.Preview {
+			LogRequest(false, time.Now(), mac, req.Scope, db)
 		}
-		script := strings.ReplaceAll(unregisteredScript, "{mac}", mac)
-		return script, nil
+		return renderMenu(MenuUnregistered, vars, req.OnMenuFallback, db)
 	} else if err != nil {
 		return "", err
-	} else if log {
-		LogRequest(true, time.Now(), mac, db)
+	} else if !req.Preview {
+		LogRequest(true, time.Now(), mac, req.Scope, db)
 	}
 
-	task, err := gorm.G[Task](db).Where("id = ?", host.TaskID).First(ctx)
-	if errors.Is(err, gorm.ErrRecordNotFound) {
-		script := strings.ReplaceAll(registeredScript, "{hostname}", host.Name)
-		return script, nil
-	} else if err != nil {
+	// The host's own task comes first, then its groups', then the scope's
+	// default task.
+	var task Task
+	var group *HostGroup
+	if host.TaskID != nil {
+		task, err = gorm.G[Task](db).Where("id = ?", host.TaskID).First(ctx)
+		if err == nil && host.PinnedRevision != nil {
+			// A pin to a revision that is gone boots the latest.
+			if revision, err := GetTaskRevision(task.ID, *host.PinnedRevision, db); err == nil {
+				task.Script = revision.Script
+			}
+		}
+	} else if group, err = pendingGroupTask(host.ID, db); err == nil {
+		task, err = gorm.G[Task](db).Where("id = ?", group.TaskID).First(ctx)
+	} else if errors.Is(err, gorm.ErrRecordNotFound) && req.DefaultTask != "" {
+		task, err = gorm.G[Task](db).Where("name = ?", req.DefaultTask).First(ctx)
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
 		return "", err
 	}
+	taskFound := err == nil
 
-	script := strings.ReplaceAll(task.Script, "{hostname}", host.Name)
+	if err := hostScriptVars(&vars, &host, group, db); err != nil {
+		return "", err
+	}
+
+	if !taskFound {
+		return renderMenu(MenuRegistered, vars, req.OnMenuFallback, db)
+	}
+
+	script, err := RenderScript(task.Script, vars)
+	if err != nil {
+		return "", fmt.Errorf("task %s: %w", task.Name, err)
+	}
+	script = strings.ReplaceAll(script, "{hostname}", host.Name)
+	script = labelPlaceholder.ReplaceAllStringFunc(script, func(placeholder string) string {
+		return vars.Labels[labelPlaceholder.FindStringSubmatch(placeholder)[1]]
+	})
+
+	if req.Preview {
+		return script, nil
+	}
 
 	var zero int = 0
 
-	if !host.PermanentTask {
+	if !host.PermanentTask && host.TaskID != nil {
 		EditHost(host.Name, host.Mac, &zero, false, host.ID, db)
 	}
+	if group != nil && !group.PermanentTask {
+		markGroupTaskBooted(group.ID, host.ID, db)
+	}
 	return script, nil
 }
+
+// hostScriptVars adds a registered host's variables to vars. group is the
+// group whose task is booting, if any.
+func hostScriptVars(vars *ScriptVars, host *Host, group *HostGroup, db *gorm.DB) error {
+	vars.Hostname = host.Name
+	vars.HostID = host.ID
+	vars.Labels = host.LabelMap()
+	vars.HasWifiKey = host.WifiKeyID != nil
+
+	if vars.IP == "" {
+		if lease, err := GetLeaseByMAC(host.Mac, db); err == nil {
+			vars.IP = lease.IP
+		} else if host.ReservedIP != nil {
+			vars.IP = *host.ReservedIP
+		}
+	}
+
+	groups, err := hostGroupNames(host.ID, db)
+	if err != nil {
+		return err
+	}
+	vars.Groups = groups
+	if group != nil {
+		vars.Group = group.Name
+	} else if len(groups) > 0 {
+		vars.Group = groups[0]
+	}
+	return nil
+}
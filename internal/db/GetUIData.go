@@ -78,38 +78,6 @@ func GetActiveTaskCount(db *gorm.DB) (totalRequests int64, err error) {
 	return
 }
 
-func GetHostsAsHTML(db *gorm.DB) (hostsHtml template.HTML, err error) {
-	ctx := context.Background()
-
-	hosts, err := gorm.G[Host](db).Preload("Task", nil).Find(ctx)
-	if err != nil {
-		return "", err
-	}
-
-	var html string
-
-	for _, u := range hosts {
-		createdAt := u.CreatedAt.Format("2006-01-02 15:04:05")
-		if u.TaskID == nil {
-			html += fmt.Sprintf(`<tr>
-				<td><a href="/hosts/edit/%d">%s</a></td>
-				<td class="text-secondary">%s</td>
-				<td class="text-secondary">%s</td>
-				<td class="text-secondary">N/A</td>
-			`, u.ID, u.Name, u.Mac, createdAt)
-		} else {
-			html += fmt.Sprintf(`<tr>
-				<td><a href="/hosts/edit/%d">%s</a></td>
-				<td class="text-secondary">%s</td>
-				<td class="text-secondary">%s</td>
-				<td class="text-secondary"><a href="/tasks/edit/%d">%s</a></td>
-			`, u.ID, u.Name, u.Mac, createdAt, u.Task.ID, u.Task.Name)
-		}
-	}
-
-	return template.HTML(html), err
-}
-
 func GetTasksAsHTML(db *gorm.DB) (tasksHtml template.HTML, err error) {
 	ctx := context.Background()
 
@@ -131,7 +99,9 @@ func GetTasksAsHTML(db *gorm.DB) (tasksHtml template.HTML, err error) {
 	return template.HTML(html), err
 }
 
-func GetWifiKeysAsHTML(db *gorm.DB) (wifiHtml template.HTML, err error) {
+// GetWifiKeysAsHTML renders the wifi key table rows. Edit links are only
+// included when showLinks is set, since the edit page reveals the key.
+func GetWifiKeysAsHTML(showLinks bool, db *gorm.DB) (wifiHtml template.HTML, err error) {
 	ctx := context.Background()
 
 	var usedKeys []*WifiKey
@@ -160,11 +130,16 @@ func GetWifiKeysAsHTML(db *gorm.DB) (wifiHtml template.HTML, err error) {
 			usedCol = `<svg  xmlns="http://www.w3.org/2000/svg"  width="24"  height="24"  viewBox="0 0 24 24"  fill="none"  stroke="currentColor"  stroke-width="2"  stroke-linecap="round"  stroke-linejoin="round"  class="icon icon-tabler icons-tabler-outline icon-tabler-check"><path stroke="none" d="M0 0h24v24H0z" fill="none"/><path d="M5 12l5 5l10 -10" /></svg>`
 		}
 
+		idCol := fmt.Sprintf("%d", u.ID)
+		if showLinks {
+			idCol = fmt.Sprintf(`<a href="/wifikeys/edit/%d">%d</a>`, u.ID, u.ID)
+		}
+
 		html += fmt.Sprintf(`<tr>
-			<td><a href="/wifikeys/edit/%d">%d</a></td>
+			<td>%s</td>
 			<td class="text-secondary">%s</td>
 			<td class="text-secondary">%s</td>
-		</tr>`, u.ID, u.ID, createdAt, usedCol)
+		</tr>`, idCol, createdAt, usedCol)
 	}
 
 	return template.HTML(html), nil
@@ -179,3 +154,61 @@ func GetUnassignedWifiKeyCount(db *gorm.DB) (int, error) {
 
 	return len(keys), nil
 }
+
+// ScopeActivity counts the boot requests and active leases of a DHCP scope.
+type ScopeActivity struct {
+	Registered   int64
+	Unregistered int64
+	ActiveLeases int64
+}
+
+// GetScopeActivity returns the activity of each scope, counting requests
+// since the given time. Requests and leases without a scope are under "".
+func GetScopeActivity(since time.Time, db *gorm.DB) (map[string]*ScopeActivity, error) {
+	activity := map[string]*ScopeActivity{}
+	get := func(scope string) *ScopeActivity {
+		if activity[scope] == nil {
+			activity[scope] = &ScopeActivity{}
+		}
+		return activity[scope]
+	}
+
+	var requests []struct {
+		Scope      string
+		Registered bool
+		Count      int64
+	}
+	err := db.Model(&Request{}).
+		Select("scope, registered, COUNT(*) AS count").
+		Where("time >= ?", since).
+		Group("scope, registered").
+		Scan(&requests).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range requests {
+		if r.Registered {
+			get(r.Scope).Registered = r.Count
+		} else {
+			get(r.Scope).Unregistered = r.Count
+		}
+	}
+
+	var leases []struct {
+		Scope string
+		Count int64
+	}
+	err = db.Model(&Lease{}).
+		Select("scope, COUNT(*) AS count").
+		Where("expires_at > ?", time.Now()).
+		Group("scope").
+		Scan(&leases).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range leases {
+		get(l.Scope).ActiveLeases = l.Count
+	}
+
+	return activity, nil
+}
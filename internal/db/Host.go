@@ -3,6 +3,7 @@ package db
 import (
 	"context"
 	"errors"
+	"net"
 	"regexp"
 	"strings"
 
@@ -17,28 +18,59 @@ type Host struct {
 	TaskID        *int
 	Task          Task
 	PermanentTask bool
+	// PinnedRevision is the revision of its task the host boots, instead of
+	// the latest. It is cleared when the host's task changes.
+	PinnedRevision *int
 
 	WifiKeyID *uint `gorm:"unique"`
 	WifiKey   WifiKey
+
+	// ReservedIP is the address the DHCP server always gives this host, and
+	// DHCPHostname the name it hands out with it. Both are optional.
+	ReservedIP   *string `gorm:"unique"`
+	DHCPHostname string
+
+	Labels []HostLabel
 }
 
-func CreateHost(mac, hostname string, taskID int, taskPerm bool, db *gorm.DB) error {
-	macRegex := regexp.MustCompile(`^([0-9A-Fa-f]{2}:){5}[0-9A-Fa-f]{2}$`)
+var ErrInvalidMAC = errors.New("invalid mac address")
+var ErrEmptyName = errors.New("name cannot be empty")
+
+var ErrInvalidIP = errors.New("invalid IPv4 address")
+var ErrOutsideSubnet = errors.New("reserved ip is outside the dhcp subnets")
+var ErrInvalidHostname = errors.New("invalid dhcp hostname")
+var ErrIPInUse = errors.New("reserved ip is already used by another host")
+var ErrNoTaskToPin = errors.New("host has no task of its own to pin a revision of")
+
+var macRegex = regexp.MustCompile(`^([0-9A-Fa-f]{2}:){5}[0-9A-Fa-f]{2}$`)
+var hostnameRegex = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?$`)
+
+// CreateHost registers a host. A taskID of 0 leaves the host without a task.
+func CreateHost(mac, hostname string, taskID int, taskPerm bool, db *gorm.DB) (*Host, error) {
 	if !macRegex.MatchString(mac) {
-		return errors.New("invalid mac address")
+		return nil, ErrInvalidMAC
+	}
+	if hostname == "" {
+		return nil, ErrEmptyName
 	}
 	mac = strings.ToLower(mac)
 
 	ctx := context.Background()
 
-	err := gorm.G[Host](db).Create(ctx, &Host{Name: hostname, Mac: mac, TaskID: &taskID, PermanentTask: taskPerm})
+	host := Host{Name: hostname, Mac: mac, PermanentTask: taskPerm}
+	if taskID != 0 {
+		host.TaskID = &taskID
+	}
+
+	err := gorm.G[Host](db).Create(ctx, &host)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return &host, nil
 }
 
+// EditHost updates a host. A nil or 0 taskID clears the host's task.
 func EditHost(name, mac string, taskID *int, taskPerm bool, id uint, db *gorm.DB) error {
 	var host Host
 
@@ -46,24 +78,118 @@ func EditHost(name, mac string, taskID *int, taskPerm bool, id uint, db *gorm.DB
 		return err
 	}
 
+	if !macRegex.MatchString(mac) {
+		return ErrInvalidMAC
+	}
+	if name == "" {
+		return ErrEmptyName
+	}
+
 	host.Name = name
-	host.Mac = mac
-	if *taskID == 0 {
+	host.Mac = strings.ToLower(mac)
+	oldTaskID := host.TaskID
+	if taskID == nil || *taskID == 0 {
 		host.TaskID = nil
 	} else {
 		host.TaskID = taskID
 	}
 	host.PermanentTask = taskPerm
+	if oldTaskID == nil || host.TaskID == nil || *oldTaskID != *host.TaskID {
+		host.PinnedRevision = nil
+	}
+
+	return db.Save(&host).Error
+}
+
+// SetHostPinnedRevision pins the host to a revision of its task or, with a
+// nil revision, has it boot the latest.
+func SetHostPinnedRevision(revision *int, id uint, db *gorm.DB) error {
+	var host Host
+	if err := db.First(&host, id).Error; err != nil {
+		return err
+	}
+
+	if revision != nil {
+		if host.TaskID == nil {
+			return ErrNoTaskToPin
+		}
+		if _, err := GetTaskRevision(*host.TaskID, *revision, db); err != nil {
+			return err
+		}
+	}
+
+	return db.Model(&host).Update("pinned_revision", revision).Error
+}
+
+// SetHostReservation sets or, with an empty ip, clears the host's reserved
+// address. If subnets is not empty, one of them must contain ip.
+func SetHostReservation(ip, hostname string, subnets []*net.IPNet, id uint, db *gorm.DB) error {
+	var reservedIP *string
+
+	if ip != "" {
+		parsed := net.ParseIP(ip).To4()
+		if parsed == nil {
+			return ErrInvalidIP
+		}
+		inSubnet := len(subnets) == 0
+		for _, subnet := range subnets {
+			inSubnet = inSubnet || subnet.Contains(parsed)
+		}
+		if !inSubnet {
+			return ErrOutsideSubnet
+		}
+		ip = parsed.String()
+		reservedIP = &ip
+	}
+
+	if hostname != "" && !hostnameRegex.MatchString(hostname) {
+		return ErrInvalidHostname
+	}
 
-	db.Save(&host)
+	result := db.Model(&Host{}).Where("id = ?", id).Updates(map[string]any{
+		"reserved_ip":   reservedIP,
+		"dhcp_hostname": hostname,
+	})
+	if errors.Is(result.Error, gorm.ErrDuplicatedKey) {
+		return ErrIPInUse
+	}
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
 
 	return nil
 }
 
+// GetReservations returns the hosts with a reserved IP or DHCP hostname.
+func GetReservations(db *gorm.DB) ([]Host, error) {
+	ctx := context.Background()
+
+	return gorm.G[Host](db).Where("reserved_ip IS NOT NULL OR dhcp_hostname != ''").Order("mac").Find(ctx)
+}
+
 func DeleteHost(id string, db *gorm.DB) error {
 	ctx := context.Background()
 
-	_, err := gorm.G[Host](db).Where("id = ?", id).Delete(ctx)
+	// Hosts are soft deleted, so free the reserved address for other hosts.
+	_, err := gorm.G[Host](db).Where("id = ?", id).Update(ctx, "reserved_ip", nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = gorm.G[HostGroupMember](db).Where("host_id = ?", id).Delete(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = gorm.G[HostLabel](db).Where("host_id = ?", id).Delete(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = gorm.G[Host](db).Where("id = ?", id).Delete(ctx)
 	if err != nil {
 		return err
 	}
@@ -74,7 +200,7 @@ func DeleteHost(id string, db *gorm.DB) error {
 func GetHostByID(id string, db *gorm.DB) (*Host, error) {
 	ctx := context.Background()
 
-	host, err := gorm.G[Host](db).Where("id = ?", id).Preload("Task", nil).Preload("WifiKey", nil).First(ctx)
+	host, err := gorm.G[Host](db).Where("id = ?", id).Preload("Task", nil).Preload("WifiKey", nil).Preload("Labels", nil).First(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -85,10 +211,70 @@ func GetHostByID(id string, db *gorm.DB) (*Host, error) {
 func GetHostByMAC(mac string, db *gorm.DB) (*Host, error) {
 	ctx := context.Background()
 
-	host, err := gorm.G[Host](db).Where("LOWER(mac) = LOWER(?)", mac).Preload("Task", nil).Preload("WifiKey", nil).First(ctx)
+	host, err := gorm.G[Host](db).Where("LOWER(mac) = LOWER(?)", mac).Preload("Task", nil).Preload("WifiKey", nil).Preload("Labels", nil).First(ctx)
 	if err != nil {
 		return nil, err
 	}
 
 	return &host, nil
 }
+
+func GetHostByName(name string, db *gorm.DB) (*Host, error) {
+	ctx := context.Background()
+
+	host, err := gorm.G[Host](db).Where("name = ?", name).Preload("Task", nil).Preload("WifiKey", nil).Preload("Labels", nil).First(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &host, nil
+}
+
+type HostFilter struct {
+	Name   string
+	Mac    string
+	TaskID *int
+	// Search matches a substring of the name or the MAC address.
+	Search string
+	// Labels the host must have. An empty value matches any value.
+	Labels map[string]string
+}
+
+// ListHosts returns a page of hosts matching filter, and the total number of
+// matches. Name, Mac and Search match substrings.
+func ListHosts(filter HostFilter, opts ListOptions, db *gorm.DB) ([]Host, int64, error) {
+	ctx := context.Background()
+
+	query := gorm.G[Host](db).Scopes()
+	if filter.Name != "" {
+		query = query.Where("LOWER(name) LIKE LOWER(?)", "%"+filter.Name+"%")
+	}
+	if filter.Mac != "" {
+		query = query.Where("LOWER(mac) LIKE LOWER(?)", "%"+filter.Mac+"%")
+	}
+	if filter.TaskID != nil {
+		query = query.Where("task_id = ?", *filter.TaskID)
+	}
+	if filter.Search != "" {
+		query = query.Where("LOWER(name) LIKE LOWER(?) OR LOWER(mac) LIKE LOWER(?)", "%"+filter.Search+"%", "%"+filter.Search+"%")
+	}
+	for key, value := range filter.Labels {
+		if value == "" {
+			query = query.Where("id IN (SELECT host_id FROM host_labels WHERE key = ?)", key)
+		} else {
+			query = query.Where("id IN (SELECT host_id FROM host_labels WHERE key = ? AND value = ?)", key, value)
+		}
+	}
+
+	total, err := query.Count(ctx, "ID")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	hosts, err := query.Preload("Task", nil).Preload("Labels", nil).Order("id").Offset(opts.Offset).Limit(opts.Limit).Find(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return hosts, total, nil
+}
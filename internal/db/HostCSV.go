@@ -0,0 +1,324 @@
+package db
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// HostCSVHeader is the header row of host CSV files. Imports need the name
+// and mac columns, in any order, and may leave out the others.
+var HostCSVHeader = []string{"name", "mac", "task", "permanent", "wifi_key", "labels"}
+
+// HostImportRow is one row of a host CSV import and what it does. Action is
+// "create", or "update" for a host whose MAC address is already registered.
+// Labels is nil if the file has no labels column, which keeps the labels of
+// updated hosts. Likewise a file without a task or permanent column keeps
+// those of updated hosts, and Task and Permanent are then the kept values.
+type HostImportRow struct {
+	Line      int
+	Name      string
+	Mac       string
+	Task      string
+	Permanent bool
+	WifiKey   string
+	Labels    map[string]string
+	Action    string
+	Errors    []string
+
+	keepTask      bool
+	keepPermanent bool
+}
+
+// HostImport is the result of a host CSV import.
+type HostImport struct {
+	DryRun  bool
+	Rows    []HostImportRow
+	Created int
+	Updated int
+}
+
+// Valid reports whether every row can be imported.
+func (i *HostImport) Valid() bool {
+	for _, row := range i.Rows {
+		if len(row.Errors) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+var errRollback = errors.New("rollback")
+
+// ImportHostsCSV creates or updates a host for every row of the CSV in r,
+// in a single transaction. Nothing is written if any row is invalid or if
+// dryRun is set, and the errors of every row are reported either way.
+// Unless allowWifiKeys is set, rows may not assign wifi keys. Keys that do
+// not exist yet are created.
+func ImportHostsCSV(r io.Reader, dryRun, allowWifiKeys bool, db *gorm.DB) (*HostImport, error) {
+	rows, err := readHostsCSV(r)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &HostImport{DryRun: dryRun, Rows: rows}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		names := map[string]int{}
+		macs := map[string]int{}
+
+		for i := range result.Rows {
+			row := &result.Rows[i]
+
+			if !macRegex.MatchString(row.Mac) {
+				row.Errors = append(row.Errors, fmt.Sprintf("invalid mac address %q", row.Mac))
+			} else if line, ok := macs[row.Mac]; ok {
+				row.Errors = append(row.Errors, fmt.Sprintf("mac address is also on line %d", line))
+			}
+			macs[row.Mac] = row.Line
+
+			if row.Name == "" {
+				row.Errors = append(row.Errors, ErrEmptyName.Error())
+			} else if line, ok := names[row.Name]; ok {
+				row.Errors = append(row.Errors, fmt.Sprintf("name is also on line %d", line))
+			}
+			names[row.Name] = row.Line
+
+			if row.WifiKey != "" && !allowWifiKeys {
+				row.Errors = append(row.Errors, "only admins may assign wifi keys")
+			}
+
+			if len(row.Errors) == 0 {
+				if err := importHostRow(row, tx); err != nil {
+					row.Errors = append(row.Errors, err.Error())
+				}
+			}
+
+			switch row.Action {
+			case "create":
+				result.Created++
+			case "update":
+				result.Updated++
+			}
+		}
+
+		if dryRun || !result.Valid() {
+			return errRollback
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errRollback) {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// readHostsCSV parses the rows of a host CSV file, whose first row is a
+// header naming the columns.
+func readHostsCSV(r io.Reader) ([]HostImportRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, errors.New("the csv is empty")
+	} else if err != nil {
+		return nil, err
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !slices.Contains(HostCSVHeader, name) {
+			return nil, fmt.Errorf("unknown column %q, expected %s", name, strings.Join(HostCSVHeader, ", "))
+		}
+		columns[name] = i
+	}
+	for _, name := range []string{"name", "mac"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("the header has no %s column", name)
+		}
+	}
+
+	var rows []HostImportRow
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		line, _ := reader.FieldPos(0)
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		_, hasTask := columns["task"]
+		_, hasPermanent := columns["permanent"]
+		row := HostImportRow{
+			Line:          line,
+			Name:          field("name"),
+			Mac:           strings.ToLower(field("mac")),
+			Task:          field("task"),
+			WifiKey:       field("wifi_key"),
+			keepTask:      !hasTask,
+			keepPermanent: !hasPermanent,
+		}
+		if row.Permanent, err = parseCSVBool(field("permanent")); err != nil {
+			row.Errors = append(row.Errors, err.Error())
+		}
+		if _, ok := columns["labels"]; ok {
+			if row.Labels, err = ParseLabels(field("labels")); err != nil {
+				row.Errors = append(row.Errors, err.Error())
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+func parseCSVBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "", "no", "n":
+		return false, nil
+	case "yes", "y":
+		return true, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("permanent must be true or false, not %q", value)
+	}
+	return b, nil
+}
+
+// importHostRow creates or updates the host of a valid row.
+func importHostRow(row *HostImportRow, tx *gorm.DB) error {
+	var taskID int
+	if row.Task != "" {
+		task, err := GetTaskByName(row.Task, tx)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("unknown task %q", row.Task)
+		} else if err != nil {
+			return err
+		}
+		taskID = task.ID
+	}
+
+	var hostID uint
+	existing, err := GetHostByMAC(row.Mac, tx)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		host, err := CreateHost(row.Mac, row.Name, taskID, row.Permanent, tx)
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return fmt.Errorf("name %q or mac address is already used by another host", row.Name)
+		} else if err != nil {
+			return err
+		}
+		hostID = host.ID
+		row.Action = "create"
+	} else if err != nil {
+		return err
+	} else {
+		newTaskID := &taskID
+		if row.keepTask {
+			newTaskID = existing.TaskID
+			if existing.TaskID != nil {
+				row.Task = existing.Task.Name
+			}
+		}
+		if row.keepPermanent {
+			row.Permanent = existing.PermanentTask
+		}
+
+		err := EditHost(row.Name, row.Mac, newTaskID, row.Permanent, existing.ID, tx)
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return fmt.Errorf("name %q is already used by another host", row.Name)
+		} else if err != nil {
+			return err
+		}
+		hostID = existing.ID
+		row.Action = "update"
+	}
+
+	if row.Labels != nil {
+		if err := SetHostLabels(row.Labels, hostID, tx); err != nil {
+			return err
+		}
+	}
+	if row.WifiKey != "" {
+		return setHostWifiKey(hostID, row.WifiKey, tx)
+	}
+	return nil
+}
+
+// setHostWifiKey assigns the wifi key key to a host, creating the key if it
+// does not exist.
+func setHostWifiKey(hostID uint, key string, tx *gorm.DB) error {
+	ctx := context.Background()
+
+	wifiKey, err := gorm.G[WifiKey](tx).Where("key = ?", key).First(ctx)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		created, err := CreateWifiKey(key, tx)
+		if err != nil {
+			return err
+		}
+		wifiKey = *created
+	} else if err != nil {
+		return err
+	}
+
+	owner, err := gorm.G[Host](tx).Where("wifi_key_id = ? AND id != ?", wifiKey.ID, hostID).First(ctx)
+	if err == nil {
+		return fmt.Errorf("wifi key is already assigned to %s", owner.Name)
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	_, err = gorm.G[Host](tx).Where("id = ?", hostID).Update(ctx, "wifi_key_id", wifiKey.ID)
+	return err
+}
+
+// ExportHostsCSV writes every host as CSV in the format ImportHostsCSV
+// reads. Wifi keys are left out unless withWifiKeys is set.
+func ExportHostsCSV(w io.Writer, withWifiKeys bool, db *gorm.DB) error {
+	ctx := context.Background()
+
+	hosts, err := gorm.G[Host](db).Preload("Task", nil).Preload("WifiKey", nil).Preload("Labels", nil).Order("name").Find(ctx)
+	if err != nil {
+		return err
+	}
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(HostCSVHeader); err != nil {
+		return err
+	}
+	for _, host := range hosts {
+		var task, wifiKey string
+		if host.TaskID != nil {
+			task = host.Task.Name
+		}
+		if withWifiKeys && host.WifiKeyID != nil {
+			wifiKey = host.WifiKey.Key
+		}
+		record := []string{host.Name, host.Mac, task, strconv.FormatBool(host.PermanentTask), wifiKey, host.LabelString(", ")}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,154 @@
+package db
+
+import (
+	"maps"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	return OpenDB(filepath.Join(t.TempDir(), "test.db"))
+}
+
+func TestImportHostsCSVKeepsMissingColumns(t *testing.T) {
+	const mac = "aa:bb:cc:dd:ee:01"
+
+	tests := []struct {
+		name          string
+		csv           string
+		wantTask      string
+		wantPermanent bool
+		wantLabels    map[string]string
+	}{
+		{"name only", "name,mac\npc01," + mac, "install", true, map[string]string{"room": "B12"}},
+		{"task cleared", "name,mac,task\npc01," + mac + ",", "", true, map[string]string{"room": "B12"}},
+		{"task changed", "name,mac,task\npc01," + mac + ",wipe", "wipe", true, map[string]string{"room": "B12"}},
+		{"permanent cleared", "name,mac,permanent\npc01," + mac + ",no", "install", false, map[string]string{"room": "B12"}},
+		{"labels replaced", "name,mac,labels\npc01," + mac + ",desk=4", "install", true, map[string]string{"desk": "4"}},
+		{"every column", "name,mac,task,permanent,labels\npc01," + mac + ",,,", "", false, map[string]string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			database := openTestDB(t)
+			install, err := CreateTask("install", "#!ipxe\nexit\n", "", "", database)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := CreateTask("wipe", "#!ipxe\nexit\n", "", "", database); err != nil {
+				t.Fatal(err)
+			}
+			host, err := CreateHost(mac, "pc01", install.ID, true, database)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := SetHostLabels(map[string]string{"room": "B12"}, host.ID, database); err != nil {
+				t.Fatal(err)
+			}
+
+			// The dry run must show what the import then does.
+			for _, dryRun := range []bool{true, false} {
+				result, err := ImportHostsCSV(strings.NewReader(tt.csv), dryRun, false, database)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !result.Valid() || result.Updated != 1 {
+					t.Fatalf("dry run %v: got %+v, want one valid update", dryRun, result.Rows)
+				}
+				row := result.Rows[0]
+				if row.Task != tt.wantTask || row.Permanent != tt.wantPermanent {
+					t.Errorf("dry run %v: row shows task %q permanent %v, want %q %v",
+						dryRun, row.Task, row.Permanent, tt.wantTask, tt.wantPermanent)
+				}
+			}
+
+			got, err := GetHostByMAC(mac, database)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var task string
+			if got.TaskID != nil {
+				task = got.Task.Name
+			}
+			if task != tt.wantTask || got.PermanentTask != tt.wantPermanent {
+				t.Errorf("host has task %q permanent %v, want %q %v", task, got.PermanentTask, tt.wantTask, tt.wantPermanent)
+			}
+			if labels := got.LabelMap(); !maps.Equal(labels, tt.wantLabels) {
+				t.Errorf("host has labels %v, want %v", labels, tt.wantLabels)
+			}
+		})
+	}
+}
+
+func TestImportHostsCSVErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		csv           string
+		allowWifiKeys bool
+		err           string
+	}{
+		{"bad mac", "name,mac\npc01,nope", false, "invalid mac address"},
+		{"mac twice", "name,mac\npc01,aa:bb:cc:dd:ee:01\npc02,AA:BB:CC:DD:EE:01", false, "also on line 2"},
+		{"name twice", "name,mac\npc01,aa:bb:cc:dd:ee:01\npc01,aa:bb:cc:dd:ee:02", false, "also on line 2"},
+		{"unknown task", "name,mac,task\npc01,aa:bb:cc:dd:ee:01,nope", false, "unknown task"},
+		{"bad permanent", "name,mac,permanent\npc01,aa:bb:cc:dd:ee:01,maybe", false, "permanent must be"},
+		{"wifi key without permission", "name,mac,wifi_key\npc01,aa:bb:cc:dd:ee:01,secret", false, "only admins"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			database := openTestDB(t)
+			result, err := ImportHostsCSV(strings.NewReader(tt.csv), false, tt.allowWifiKeys, database)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Valid() {
+				t.Fatal("import is valid")
+			}
+
+			var errs []string
+			for _, row := range result.Rows {
+				errs = append(errs, row.Errors...)
+			}
+			if !strings.Contains(strings.Join(errs, "; "), tt.err) {
+				t.Fatalf("got errors %v, want one containing %q", errs, tt.err)
+			}
+
+			_, total, err := ListHosts(HostFilter{}, ListOptions{Limit: 10}, database)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if total != 0 {
+				t.Fatalf("an invalid import created %d hosts", total)
+			}
+		})
+	}
+}
+
+func TestReadHostsCSVHeader(t *testing.T) {
+	tests := []struct {
+		name string
+		csv  string
+		err  string
+	}{
+		{"empty", "", "empty"},
+		{"unknown column", "name,mac,colour\n", "unknown column"},
+		{"no mac", "name,task\n", "no mac column"},
+		{"any order", "MAC, Name\n", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readHostsCSV(strings.NewReader(tt.csv))
+			if tt.err == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			} else if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("got %v, want an error containing %q", err, tt.err)
+			}
+		})
+	}
+}
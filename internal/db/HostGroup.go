@@ -0,0 +1,243 @@
+package db
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// HostGroup is a set of hosts given a task together, such as the machines
+// of one room. Members without a task of their own boot the group's task,
+// once each unless PermanentTask is set.
+type HostGroup struct {
+	gorm.Model
+	Name          string `gorm:"unique"`
+	TaskID        *int
+	Task          Task
+	PermanentTask bool
+	Hosts         []Host `gorm:"many2many:host_group_members"`
+}
+
+// HostGroupMember joins hosts to groups. TaskBooted records that the host
+// has booted the group's one-off task.
+type HostGroupMember struct {
+	HostGroupID uint `gorm:"primaryKey"`
+	HostID      uint `gorm:"primaryKey"`
+	TaskBooted  bool
+}
+
+// GroupMember is a member of a group and its state.
+type GroupMember struct {
+	Host       Host
+	TaskBooted bool
+	// Lease is the host's last DHCP lease, or nil if none was seen.
+	Lease *Lease
+}
+
+// setupHostGroups registers the join table, which has to happen before
+// either side of it is migrated.
+func setupHostGroups(db *gorm.DB) error {
+	return db.SetupJoinTable(&HostGroup{}, "Hosts", &HostGroupMember{})
+}
+
+func hostsByID(ids []uint, db *gorm.DB) ([]Host, error) {
+	if len(ids) == 0 {
+		return []Host{}, nil
+	}
+
+	ctx := context.Background()
+
+	hosts, err := gorm.G[Host](db).Where("id IN ?", ids).Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(hosts) != len(ids) {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	return hosts, nil
+}
+
+// CreateHostGroup creates a group of the hosts hostIDs. A taskID of 0
+// leaves the group without a task.
+func CreateHostGroup(name string, taskID int, taskPerm bool, hostIDs []uint, db *gorm.DB) (*HostGroup, error) {
+	if name == "" {
+		return nil, ErrEmptyName
+	}
+
+	hosts, err := hostsByID(hostIDs, db)
+	if err != nil {
+		return nil, err
+	}
+
+	group := HostGroup{Name: name, PermanentTask: taskPerm, Hosts: hosts}
+	if taskID != 0 {
+		group.TaskID = &taskID
+	}
+
+	if err := db.Create(&group).Error; err != nil {
+		return nil, err
+	}
+
+	return &group, nil
+}
+
+// EditHostGroup updates a group and replaces its members, unless hostIDs
+// is nil. A nil or 0 taskID clears the group's task. Changing the task makes
+// every member boot it again.
+func EditHostGroup(name string, taskID *int, taskPerm bool, hostIDs []uint, id uint, db *gorm.DB) error {
+	if name == "" {
+		return ErrEmptyName
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var group HostGroup
+		if err := tx.First(&group, id).Error; err != nil {
+			return err
+		}
+
+		if taskID != nil && *taskID == 0 {
+			taskID = nil
+		}
+		taskChanged := (group.TaskID == nil) != (taskID == nil) ||
+			(taskID != nil && *group.TaskID != *taskID) ||
+			group.PermanentTask != taskPerm
+
+		group.Name = name
+		group.TaskID = taskID
+		group.PermanentTask = taskPerm
+		if err := tx.Omit("Hosts").Save(&group).Error; err != nil {
+			return err
+		}
+
+		if hostIDs != nil {
+			hosts, err := hostsByID(hostIDs, tx)
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(&group).Association("Hosts").Replace(hosts); err != nil {
+				return err
+			}
+		}
+
+		if taskChanged {
+			return tx.Model(&HostGroupMember{}).Where("host_group_id = ?", id).Update("task_booted", false).Error
+		}
+		return nil
+	})
+}
+
+func DeleteHostGroup(id string, db *gorm.DB) error {
+	ctx := context.Background()
+
+	_, err := gorm.G[HostGroupMember](db).Where("host_group_id = ?", id).Delete(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = gorm.G[HostGroup](db).Where("id = ?", id).Delete(ctx)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func GetHostGroupByID(id string, db *gorm.DB) (*HostGroup, error) {
+	ctx := context.Background()
+
+	group, err := gorm.G[HostGroup](db).Where("id = ?", id).Preload("Task", nil).Preload("Hosts", nil).First(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &group, nil
+}
+
+func GetHostGroups(db *gorm.DB) ([]HostGroup, error) {
+	ctx := context.Background()
+
+	groups, err := gorm.G[HostGroup](db).Preload("Task", nil).Preload("Hosts", nil).Order("name").Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return groups, nil
+}
+
+// GetGroupMembers returns the members of a group with their state, ordered
+// by name.
+func GetGroupMembers(id uint, db *gorm.DB) ([]GroupMember, error) {
+	ctx := context.Background()
+
+	memberships, err := gorm.G[HostGroupMember](db).Where("host_group_id = ?", id).Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	booted := map[uint]bool{}
+	ids := make([]uint, 0, len(memberships))
+	for _, m := range memberships {
+		booted[m.HostID] = m.TaskBooted
+		ids = append(ids, m.HostID)
+	}
+
+	hosts, err := gorm.G[Host](db).Where("id IN ?", ids).Preload("Task", nil).Order("name").Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	leases, err := GetLeases(db)
+	if err != nil {
+		return nil, err
+	}
+	leaseByMac := map[string]*Lease{}
+	for i := range leases {
+		leaseByMac[leases[i].Mac] = &leases[i]
+	}
+
+	members := make([]GroupMember, 0, len(hosts))
+	for _, host := range hosts {
+		members = append(members, GroupMember{
+			Host:       host,
+			TaskBooted: booted[host.ID],
+			Lease:      leaseByMac[host.Mac],
+		})
+	}
+
+	return members, nil
+}
+
+// pendingGroupTask returns the first group, by name, whose task the host
+// should boot: a permanent task, or a one-off task it has not booted yet.
+func pendingGroupTask(hostID uint, db *gorm.DB) (*HostGroup, error) {
+	var group HostGroup
+	err := db.
+		Joins("JOIN host_group_members ON host_group_members.host_group_id = host_groups.id").
+		Where("host_group_members.host_id = ? AND host_groups.task_id IS NOT NULL", hostID).
+		Where("host_groups.permanent_task OR NOT host_group_members.task_booted").
+		Order("host_groups.name").
+		First(&group).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &group, nil
+}
+
+// hostGroupNames returns the names of the host's groups, sorted.
+func hostGroupNames(hostID uint, db *gorm.DB) ([]string, error) {
+	var names []string
+	err := db.Model(&HostGroup{}).
+		Joins("JOIN host_group_members ON host_group_members.host_group_id = host_groups.id").
+		Where("host_group_members.host_id = ?", hostID).
+		Order("host_groups.name").
+		Pluck("host_groups.name", &names).Error
+	return names, err
+}
+
+// markGroupTaskBooted records that the host has booted the group's task.
+func markGroupTaskBooted(groupID, hostID uint, db *gorm.DB) error {
+	return db.Model(&HostGroupMember{}).
+		Where("host_group_id = ? AND host_id = ?", groupID, hostID).
+		Update("task_booted", true).Error
+}
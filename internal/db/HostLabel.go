@@ -0,0 +1,136 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// HostLabel is a free-form key=value label on a host, such as room=B12.
+type HostLabel struct {
+	ID     uint   `gorm:"primaryKey"`
+	HostID uint   `gorm:"uniqueIndex:idx_host_label_key"`
+	Key    string `gorm:"uniqueIndex:idx_host_label_key;index"`
+	Value  string
+}
+
+var ErrInvalidLabel = errors.New("invalid label")
+
+var labelKeyRegex = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,62}$`)
+
+const maxLabelValue = 255
+
+// LabelMap returns the host's labels by key.
+func (h Host) LabelMap() map[string]string {
+	labels := make(map[string]string, len(h.Labels))
+	for _, label := range h.Labels {
+		labels[label.Key] = label.Value
+	}
+	return labels
+}
+
+// LabelString returns the host's labels as key=value pairs sorted by key and
+// joined by sep, in the format ParseLabels reads.
+func (h Host) LabelString(sep string) string {
+	pairs := make([]string, 0, len(h.Labels))
+	for _, label := range h.Labels {
+		pairs = append(pairs, label.Key+"="+label.Value)
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, sep)
+}
+
+// ValidateLabel checks a label's key and value.
+func ValidateLabel(key, value string) error {
+	if !labelKeyRegex.MatchString(key) {
+		return fmt.Errorf("%w: key %q may only use letters, digits, '_', '.' and '-'", ErrInvalidLabel, key)
+	}
+	if value == "" {
+		return fmt.Errorf("%w: %s has no value", ErrInvalidLabel, key)
+	}
+	if len(value) > maxLabelValue || strings.ContainsAny(value, ",\r\n") {
+		return fmt.Errorf("%w: the value of %s must be at most %d characters without commas or line breaks", ErrInvalidLabel, key, maxLabelValue)
+	}
+	return nil
+}
+
+// ParseLabels reads key=value labels separated by commas or line breaks.
+func ParseLabels(s string) (map[string]string, error) {
+	return parseLabels(s, false)
+}
+
+// ParseLabelFilter reads a label filter in the format of ParseLabels, where
+// a key on its own matches any value. Such keys map to "".
+func ParseLabelFilter(s string) (map[string]string, error) {
+	return parseLabels(s, true)
+}
+
+func parseLabels(s string, bareKeys bool) (map[string]string, error) {
+	labels := map[string]string{}
+	for _, pair := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' || r == '\r' }) {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		key, value, found := strings.Cut(pair, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !found && bareKeys {
+			if !labelKeyRegex.MatchString(key) {
+				return nil, fmt.Errorf("%w: key %q may only use letters, digits, '_', '.' and '-'", ErrInvalidLabel, key)
+			}
+			labels[key] = ""
+			continue
+		}
+		if !found {
+			return nil, fmt.Errorf("%w: %q is not key=value", ErrInvalidLabel, pair)
+		}
+		if err := ValidateLabel(key, value); err != nil {
+			return nil, err
+		}
+		if _, ok := labels[key]; ok {
+			return nil, fmt.Errorf("%w: %s is given twice", ErrInvalidLabel, key)
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+// SetHostLabels replaces the labels of a host.
+func SetHostLabels(labels map[string]string, hostID uint, db *gorm.DB) error {
+	for key, value := range labels {
+		if err := ValidateLabel(key, value); err != nil {
+			return err
+		}
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("host_id = ?", hostID).Delete(&HostLabel{}).Error; err != nil {
+			return err
+		}
+		if len(labels) == 0 {
+			return nil
+		}
+
+		rows := make([]HostLabel, 0, len(labels))
+		for key, value := range labels {
+			rows = append(rows, HostLabel{HostID: hostID, Key: key, Value: value})
+		}
+		return tx.Create(&rows).Error
+	})
+}
+
+// GetLabelKeys returns every label key in use, sorted.
+func GetLabelKeys(db *gorm.DB) ([]string, error) {
+	var keys []string
+	err := db.Model(&HostLabel{}).
+		Joins("JOIN hosts ON hosts.id = host_labels.host_id AND hosts.deleted_at IS NULL").
+		Distinct("host_labels.key").
+		Order("host_labels.key").
+		Pluck("host_labels.key", &keys).Error
+	return keys, err
+}
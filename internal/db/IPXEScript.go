@@ -0,0 +1,200 @@
+package db
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// ScriptIssue is a problem CheckIPXEScript found on a line of a script.
+type ScriptIssue struct {
+	Line    int
+	Message string
+	// Warning is set for problems that do not stop the script running,
+	// such as a menu item with no label to go to.
+	Warning bool
+}
+
+func (i ScriptIssue) String() string {
+	return fmt.Sprintf("line %d: %s", i.Line, i.Message)
+}
+
+// HasScriptErrors reports whether any of issues is not a warning.
+func HasScriptErrors(issues []ScriptIssue) bool {
+	return slices.ContainsFunc(issues, func(i ScriptIssue) bool { return !i.Warning })
+}
+
+// ipxeCommands are the commands an iPXE build can have. Which ones a given
+// binary has depends on how it was built, and custom builds can add their
+// own, so commands not listed here are only warned about.
+var ipxeCommands = map[string]bool{}
+
+func init() {
+	for _, cmd := range strings.Fields(`
+		autoboot boot certfree certstat certstore chain choose clear colour
+		config console cpair cpuid dhcp digest echo exit fcels fcstat fdt form
+		gdbstub goto help ibstat ifclose ifconf ifopen ifstat imgargs
+		imgdecrypt imgexec imgextract imgfetch imgfree imgload imgmem
+		imgselect imgstat imgtrust imgverify inc initrd ipstat iseq isset item
+		iwlist iwstat kernel login lotest md5sum menu module neighbour
+		nslookup nstat ntp param params paramstring pciscan ping poweroff
+		present profstat prompt pxebs read reboot route sanboot sanhook
+		sanunhook set sha1sum shell shim show sleep stoppxe sync time usbscan
+		vcreate vdestroy`) {
+		ipxeCommands[cmd] = true
+	}
+}
+
+// templateAction matches a template action, which may span lines.
+var templateAction = regexp.MustCompile(`(?s)\{\{.*?\}\}`)
+
+// templated stands in for a template action while a script is checked.
+// Words containing it, like those containing iPXE settings, are only known
+// when the script runs and are not checked.
+const templated = "\x00"
+
+func isDynamic(word string) bool {
+	return strings.Contains(word, templated) || strings.Contains(word, "${")
+}
+
+// CheckIPXEScript checks a task script for iPXE mistakes: a missing
+// #!ipxe header and goto or choose --default targets with no :label.
+// Unknown commands and menu items whose label is missing are warnings, the
+// first since the iPXE build may have them and the second since the menu
+// still shows. Template actions are skipped over, so the script
+// need not be rendered first.
+func CheckIPXEScript(script string) []ScriptIssue {
+	masked := templateAction.ReplaceAllStringFunc(script, func(action string) string {
+		return templated + strings.Repeat("\n", strings.Count(action, "\n"))
+	})
+	lines := strings.Split(masked, "\n")
+
+	var issues []ScriptIssue
+	add := func(line int, warning bool, format string, args ...any) {
+		issues = append(issues, ScriptIssue{Line: line, Message: fmt.Sprintf(format, args...), Warning: warning})
+	}
+
+	// iPXE only looks at the first six bytes.
+	if first := strings.TrimSpace(lines[0]); !strings.HasPrefix(first, "#!ipxe") {
+		add(1, false, `script must start with "#!ipxe"`)
+	}
+
+	type labelRef struct {
+		line    int
+		label   string
+		command string
+		warning bool
+	}
+	var refs []labelRef
+	labels := map[string]int{}
+	templatedLabels := false
+
+	for i, line := range lines {
+		n := i + 1
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if label, ok := strings.CutPrefix(line, ":"); ok {
+			label = strings.TrimSpace(label)
+			switch {
+			case label == "":
+				add(n, false, "label has no name")
+			case isDynamic(label):
+				templatedLabels = true
+			case labels[label] != 0:
+				add(n, true, "label %q is already on line %d, goto will go there", label, labels[label])
+			default:
+				labels[label] = n
+			}
+			continue
+		}
+
+		for _, command := range splitCommands(line) {
+			args := strings.Fields(command)
+			if len(args) == 0 || isDynamic(args[0]) {
+				continue
+			}
+			if !ipxeCommands[args[0]] {
+				add(n, true, "unknown command %q", args[0])
+				continue
+			}
+
+			switch args[0] {
+			case "goto":
+				if len(args) < 2 {
+					add(n, false, "goto needs a label")
+				} else {
+					refs = append(refs, labelRef{n, args[1], "goto", false})
+				}
+			case "choose":
+				opts, pos := parseOptions(args[1:], "default", "d", "timeout", "t", "menu", "m")
+				if len(pos) == 0 {
+					add(n, false, "choose needs a setting to store the choice in")
+				}
+				if def := opts["default"] + opts["d"]; def != "" {
+					refs = append(refs, labelRef{n, def, "choose --default", false})
+				}
+			case "item":
+				opts, pos := parseOptions(args[1:], "key", "k", "menu", "m")
+				_, gap := opts["gap"]
+				_, g := opts["g"]
+				if len(pos) > 0 && !gap && !g {
+					refs = append(refs, labelRef{n, pos[0], "item", true})
+				}
+			}
+		}
+	}
+
+	// A label made by a template could be any of the targets.
+	if !templatedLabels {
+		for _, ref := range refs {
+			if isDynamic(ref.label) || labels[ref.label] != 0 {
+				continue
+			}
+			add(ref.line, ref.warning, "%s target %q has no :%s label", ref.command, ref.label, ref.label)
+		}
+	}
+
+	slices.SortStableFunc(issues, func(a, b ScriptIssue) int { return a.Line - b.Line })
+	return issues
+}
+
+// splitCommands splits a line on the && and || operators.
+func splitCommands(line string) []string {
+	var commands []string
+	for _, part := range strings.Split(line, "||") {
+		commands = append(commands, strings.Split(part, "&&")...)
+	}
+	return commands
+}
+
+// parseOptions splits command arguments into options and positional
+// arguments. withValue lists the options, long or short, that take a value.
+func parseOptions(args []string, withValue ...string) (map[string]string, []string) {
+	opts := map[string]string{}
+	var pos []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, isOpt := strings.CutPrefix(arg, "--")
+		if !isOpt {
+			name, isOpt = strings.CutPrefix(arg, "-")
+		}
+		if !isOpt || name == "" {
+			pos = append(pos, args[i:]...)
+			break
+		}
+
+		if key, value, ok := strings.Cut(name, "="); ok {
+			opts[key] = value
+		} else if slices.Contains(withValue, name) && i+1 < len(args) {
+			opts[name] = args[i+1]
+			i++
+		} else {
+			opts[name] = ""
+		}
+	}
+	return opts, pos
+}
@@ -0,0 +1,55 @@
+package db
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCheckIPXEScript(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []ScriptIssue
+	}{
+		{"valid", "#!ipxe\ndhcp\nchain http://10.0.0.1/boot.ipxe", nil},
+		{"header with trailing text", "#!ipxe foo\nboot", nil},
+		{"missing header", "dhcp\nboot", []ScriptIssue{{1, `script must start with "#!ipxe"`, false}}},
+		{"unknown command", "#!ipxe\nfrobnicate", []ScriptIssue{{2, `unknown command "frobnicate"`, true}}},
+		{"wireless commands", "#!ipxe\niwstat\niwlist\nparamstring", nil},
+		{"command after operator", "#!ipxe\ndhcp || frobnicate", []ScriptIssue{{2, `unknown command "frobnicate"`, true}}},
+		{"goto without label", "#!ipxe\ngoto", []ScriptIssue{{2, "goto needs a label", false}}},
+		{"goto missing target", "#!ipxe\ngoto end", []ScriptIssue{{2, `goto target "end" has no :end label`, false}}},
+		{"goto target defined later", "#!ipxe\ngoto end\n:end", nil},
+		{"goto dynamic target", "#!ipxe\ngoto ${target}", nil},
+		{"goto templated target", "#!ipxe\ngoto {{ .Hostname }}", nil},
+		{"templated label", "#!ipxe\ngoto end\n:{{ .Hostname }}", nil},
+		{"empty label", "#!ipxe\n:", []ScriptIssue{{2, "label has no name", false}}},
+		{"duplicate label", "#!ipxe\n:a\n:a", []ScriptIssue{{3, `label "a" is already on line 2, goto will go there`, true}}},
+		{"choose without setting", "#!ipxe\nchoose", []ScriptIssue{{2, "choose needs a setting to store the choice in", false}}},
+		{"choose missing default", "#!ipxe\nchoose --default a target", []ScriptIssue{{2, `choose --default target "a" has no :a label`, false}}},
+		{"choose short default", "#!ipxe\nchoose -d a target\n:a", nil},
+		{"choose default with equals", "#!ipxe\nchoose --default=b target", []ScriptIssue{{2, `choose --default target "b" has no :b label`, false}}},
+		{"item missing label", "#!ipxe\nitem a Boot A", []ScriptIssue{{2, `item target "a" has no :a label`, true}}},
+		{"item with key", "#!ipxe\nitem --key x a Boot A\n:a", nil},
+		{"gap item", "#!ipxe\nitem --gap -- Heading", nil},
+		{"comment", "#!ipxe\n# frobnicate", nil},
+		{"multi line template action", "#!ipxe\n{{ if .Hostname }}\n{{ end }}\nfrobnicate", []ScriptIssue{{4, `unknown command "frobnicate"`, true}}},
+		{"issues sorted by line", "dhcp\ngoto x\nfrobnicate", []ScriptIssue{
+			{1, `script must start with "#!ipxe"`, false},
+			{2, `goto target "x" has no :x label`, false},
+			{3, `unknown command "frobnicate"`, true},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := CheckIPXEScript(tt.script)
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			if HasScriptErrors(got) != slices.ContainsFunc(tt.want, func(i ScriptIssue) bool { return !i.Warning }) {
+				t.Fatalf("HasScriptErrors(%v) = %v", got, HasScriptErrors(got))
+			}
+		})
+	}
+}
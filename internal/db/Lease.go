@@ -0,0 +1,107 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Lease is the last DHCP lease seen for a MAC address.
+type Lease struct {
+	gorm.Model
+	Scope       string
+	Mac         string `gorm:"uniqueIndex"`
+	IP          string
+	Hostname    string
+	VendorClass string
+	ClientArch  int // -1 if unknown
+	ExpiresAt   time.Time
+
+	// HostID is the registered host with this MAC, filled in by GetLeases.
+	HostID uint `gorm:"->;-:migration"`
+}
+
+var archNames = map[int]string{
+	0:  "x86 BIOS",
+	1:  "NEC PC98",
+	6:  "EFI IA32",
+	7:  "EFI x64",
+	9:  "EFI x86-64",
+	10: "EFI ARM32",
+	11: "EFI ARM64",
+}
+
+func (l Lease) Active() bool {
+	return time.Now().Before(l.ExpiresAt)
+}
+
+// ArchName describes ClientArch, or returns "" if it is unknown.
+func (l Lease) ArchName() string {
+	if l.ClientArch < 0 {
+		return ""
+	}
+	if name, ok := archNames[l.ClientArch]; ok {
+		return name
+	}
+	return "arch " + strconv.Itoa(l.ClientArch)
+}
+
+// SaveLease records a lease, replacing any earlier lease for the same MAC.
+// Renewals often leave out the hostname, vendor class and architecture, so
+// empty values keep what was stored before, as does an empty scope.
+func SaveLease(lease Lease, db *gorm.DB) error {
+	lease.Mac = strings.ToLower(lease.Mac)
+
+	return db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "mac"}},
+		DoUpdates: clause.Assignments(map[string]any{
+			"updated_at":   time.Now(),
+			"deleted_at":   nil,
+			"ip":           lease.IP,
+			"scope":        gorm.Expr("COALESCE(NULLIF(excluded.scope, ''), leases.scope)"),
+			"expires_at":   lease.ExpiresAt,
+			"hostname":     gorm.Expr("COALESCE(NULLIF(excluded.hostname, ''), leases.hostname)"),
+			"vendor_class": gorm.Expr("COALESCE(NULLIF(excluded.vendor_class, ''), leases.vendor_class)"),
+			"client_arch":  gorm.Expr("CASE WHEN excluded.client_arch < 0 THEN leases.client_arch ELSE excluded.client_arch END"),
+		}),
+	}).Create(&lease).Error
+}
+
+// GetLeases returns the leases that have not expired, ordered by IP.
+func GetLeases(db *gorm.DB) ([]Lease, error) {
+	var leases []Lease
+
+	err := db.Model(&Lease{}).
+		Select("leases.*, hosts.id AS host_id").
+		Joins("LEFT JOIN hosts ON hosts.mac = leases.mac AND hosts.deleted_at IS NULL").
+		Where("leases.expires_at > ?", time.Now()).
+		Find(&leases).Error
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(leases, func(i, j int) bool {
+		return bytes.Compare(net.ParseIP(leases[i].IP).To16(), net.ParseIP(leases[j].IP).To16()) < 0
+	})
+
+	return leases, nil
+}
+
+// GetLeaseByMAC returns the last lease for mac, which may have expired.
+func GetLeaseByMAC(mac string, db *gorm.DB) (*Lease, error) {
+	ctx := context.Background()
+
+	lease, err := gorm.G[Lease](db).Where("mac = ?", strings.ToLower(mac)).First(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &lease, nil
+}
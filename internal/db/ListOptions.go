@@ -0,0 +1,7 @@
+package db
+
+// ListOptions selects a page of results from a List function.
+type ListOptions struct {
+	Offset int
+	Limit  int
+}
@@ -10,16 +10,36 @@ import (
 
 func OpenDB(dbPath string) *gorm.DB {
 	db, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Silent),
+		Logger:         logger.Default.LogMode(logger.Silent),
+		TranslateError: true,
 	})
 	if err != nil {
 		panic(fmt.Sprintf("failed to open db: %s", err))
 	}
 
 	db.AutoMigrate(&Task{})
+	db.AutoMigrate(&TaskRevision{})
 	db.AutoMigrate(&Host{})
+	db.AutoMigrate(&HostLabel{})
 	db.AutoMigrate(&Request{})
 	db.AutoMigrate(&WifiKey{})
+	db.AutoMigrate(&User{})
+	db.AutoMigrate(&Session{})
+	db.AutoMigrate(&APIToken{})
+	db.AutoMigrate(&Lease{})
+	db.AutoMigrate(&BootMenu{})
+
+	if err := setupHostGroups(db); err != nil {
+		panic(fmt.Sprintf("failed to set up host groups: %s", err))
+	}
+	db.AutoMigrate(&HostGroup{})
+
+	if err := MigrateUserRoles(db); err != nil {
+		panic(fmt.Sprintf("failed to migrate user roles: %s", err))
+	}
+	if err := MigrateTaskRevisions(db); err != nil {
+		panic(fmt.Sprintf("failed to migrate task revisions: %s", err))
+	}
 
 	return db
 }
@@ -12,12 +12,14 @@ type Request struct {
 	Registered bool
 	Time       time.Time
 	Mac        string
+	// Scope is the DHCP scope the client booted from, or "" if unknown.
+	Scope string
 }
 
-func LogRequest(registered bool, datetime time.Time, mac string, db *gorm.DB) error {
+func LogRequest(registered bool, datetime time.Time, mac, scope string, db *gorm.DB) error {
 	ctx := context.Background()
 
-	err := gorm.G[Request](db).Create(ctx, &Request{Registered: registered, Time: datetime, Mac: mac})
+	err := gorm.G[Request](db).Create(ctx, &Request{Registered: registered, Time: datetime, Mac: mac, Scope: scope})
 	if err != nil {
 		return err
 	}
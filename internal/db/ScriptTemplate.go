@@ -0,0 +1,242 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+)
+
+// ScriptVars are the variables a task script or boot menu is rendered with,
+// e.g. {{ .Hostname }} or {{ .Labels.room }}. Unregistered hosts only have
+// MAC, IP, Scope, Server and Vars.
+type ScriptVars struct {
+	Hostname string
+	MAC      string
+	HostID   uint
+	// IP is the address the request came from, or else the host's lease or
+	// reserved address.
+	IP    string
+	Scope string
+	// Server is the host and port the client reached pxehub on.
+	Server string
+	// Group is the group whose task is booting, or else the first of Groups.
+	Group  string
+	Groups []string
+	Labels map[string]string
+	// WifiKeyURL is where the host fetches its wifi key, assigning one if
+	// it has none. HasWifiKey reports whether one is assigned already.
+	WifiKeyURL string
+	HasWifiKey bool
+	// Vars are the custom variables set in the config.
+	Vars map[string]string
+}
+
+var ErrInvalidScript = errors.New("invalid script")
+
+// maxScriptSize caps the rendered size of a script.
+const maxScriptSize = 1 << 20
+
+// maxRenderTime caps how long a script may take to render, so a slow
+// script cannot hold up a boot request. It is checked as the script
+// writes; maxRangeSteps bounds the work done in between.
+const maxRenderTime = 2 * time.Second
+
+// maxRangeSteps caps the number of range iterations in one render.
+const maxRangeSteps = 100_000
+
+// rangeBudgetFunc is the function checkScriptNode puts in front of each
+// range's collection. It is only defined while a script renders, so
+// scripts cannot call it themselves.
+const rangeBudgetFunc = "rangeLimit"
+
+// rangeFields are the ScriptVars fields a script may range over. Their
+// size is set by the host and config, not by the script.
+var rangeFields = []string{"Groups", "Labels", "Vars"}
+
+// scriptFuncs are the only functions, besides the text/template builtins,
+// that scripts can call. None of them touch anything outside their
+// arguments.
+var scriptFuncs = template.FuncMap{
+	"lower":     strings.ToLower,
+	"upper":     strings.ToUpper,
+	"trim":      strings.TrimSpace,
+	"contains":  strings.Contains,
+	"hasPrefix": strings.HasPrefix,
+	"hasSuffix": strings.HasSuffix,
+	"replace": func(old, new, s string) string {
+		return strings.ReplaceAll(s, old, new)
+	},
+	"join": func(sep string, items []string) string {
+		return strings.Join(items, sep)
+	},
+	"default": func(fallback, value string) string {
+		if value == "" {
+			return fallback
+		}
+		return value
+	},
+}
+
+var parseErrorPrefix = regexp.MustCompile(`^template: script:(\d+):(\d+:)?\s*`)
+
+// ParseScript parses a task script or boot menu as a template. Errors name
+// the line they are on.
+func ParseScript(script string) (*template.Template, error) {
+	tmpl, err := template.New("script").Option("missingkey=zero").Funcs(scriptFuncs).Parse(script)
+	if err != nil {
+		msg := parseErrorPrefix.ReplaceAllString(err.Error(), "line $1: ")
+		return nil, fmt.Errorf("%w: %s", ErrInvalidScript, msg)
+	}
+
+	for _, t := range tmpl.Templates() {
+		if t.Tree == nil {
+			continue
+		}
+		if err := checkScriptNode(t.Tree.Root, t.Tree); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidScript, err)
+		}
+	}
+
+	return tmpl, nil
+}
+
+// ValidateScript reports whether a task script parses as a template and
+// has no iPXE errors. Warnings from CheckIPXEScript do not fail it.
+func ValidateScript(script string) error {
+	if _, err := ParseScript(script); err != nil {
+		return err
+	}
+
+	var errs []string
+	for _, issue := range CheckIPXEScript(script) {
+		if !issue.Warning {
+			errs = append(errs, issue.String())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidScript, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// checkScriptNode only lets ranges go over the collections in rangeFields,
+// since ranging over a number could keep a boot request busy for as long
+// as the script likes without writing anything. Each range's collection is
+// passed through rangeBudgetFunc, which stops the script once it has made
+// maxRangeSteps steps. Template calls are rejected, since a template can
+// call others many times over.
+func checkScriptNode(node parse.Node, tree *parse.Tree) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := checkScriptNode(child, tree); err != nil {
+				return err
+			}
+		}
+	case *parse.RangeNode:
+		if !isRangeField(n.Pipe) {
+			return fmt.Errorf("line %s: range only works over %s", nodeLine(n, tree), strings.Join(rangeFields, ", "))
+		}
+		cmd := n.Pipe.Cmds[0]
+		budget := parse.NewIdentifier(rangeBudgetFunc).SetTree(tree).SetPos(cmd.Position())
+		cmd.Args = append([]parse.Node{budget}, cmd.Args...)
+
+		if err := checkScriptNode(n.List, tree); err != nil {
+			return err
+		}
+		return checkScriptNode(n.ElseList, tree)
+	case *parse.IfNode:
+		if err := checkScriptNode(n.List, tree); err != nil {
+			return err
+		}
+		return checkScriptNode(n.ElseList, tree)
+	case *parse.WithNode:
+		if err := checkScriptNode(n.List, tree); err != nil {
+			return err
+		}
+		return checkScriptNode(n.ElseList, tree)
+	case *parse.TemplateNode:
+		return fmt.Errorf("line %s: calling templates is not allowed", nodeLine(n, tree))
+	}
+	return nil
+}
+
+// nodeLine returns the line of the script node is on.
+func nodeLine(node parse.Node, tree *parse.Tree) string {
+	location, _ := tree.ErrorContext(node)
+	return strings.Split(location, ":")[1]
+}
+
+// isRangeField reports whether pipe is just one of rangeFields, as in
+// {{ range .Groups }} or {{ range $i, $g := $.Groups }}.
+func isRangeField(pipe *parse.PipeNode) bool {
+	if len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return false
+	}
+
+	var ident []string
+	switch arg := pipe.Cmds[0].Args[0].(type) {
+	case *parse.FieldNode:
+		ident = arg.Ident
+	case *parse.VariableNode:
+		if arg.Ident[0] != "$" {
+			return false
+		}
+		ident = arg.Ident[1:]
+	default:
+		return false
+	}
+	return len(ident) == 1 && slices.Contains(rangeFields, ident[0])
+}
+
+// limitedWriter fails once more than maxScriptSize bytes are written, or
+// once the deadline has passed.
+type limitedWriter struct {
+	out      strings.Builder
+	deadline time.Time
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if time.Now().After(w.deadline) {
+		return 0, fmt.Errorf("script took longer than %s to render", maxRenderTime)
+	}
+	if w.out.Len()+len(p) > maxScriptSize {
+		return 0, fmt.Errorf("script is larger than %d bytes", maxScriptSize)
+	}
+	return w.out.Write(p)
+}
+
+// RenderScript renders a task script or boot menu with vars.
+func RenderScript(script string, vars ScriptVars) (string, error) {
+	tmpl, err := ParseScript(script)
+	if err != nil {
+		return "", err
+	}
+
+	steps := 0
+	tmpl.Funcs(template.FuncMap{rangeBudgetFunc: func(items any) (any, error) {
+		if v := reflect.ValueOf(items); v.Kind() == reflect.Slice || v.Kind() == reflect.Map {
+			steps += v.Len()
+		}
+		if steps > maxRangeSteps {
+			return nil, fmt.Errorf("script ranges over more than %d items", maxRangeSteps)
+		}
+		return items, nil
+	}})
+
+	out := limitedWriter{deadline: time.Now().Add(maxRenderTime)}
+	if err := tmpl.Execute(&out, vars); err != nil {
+		msg := parseErrorPrefix.ReplaceAllString(err.Error(), "line $1: ")
+		return "", fmt.Errorf("%w: %s", ErrInvalidScript, msg)
+	}
+	return out.out.String(), nil
+}
@@ -0,0 +1,126 @@
+package db
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseScriptSandbox(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		err    string
+	}{
+		{"range over groups", "{{ range .Groups }}{{ . }}{{ end }}", ""},
+		{"range over root field", "{{ range $k, $v := $.Labels }}{{ $k }}{{ end }}", ""},
+		{"ranges one after another", "{{ range .Groups }}{{ end }}{{ range .Vars }}{{ end }}", ""},
+		{"range in with", "{{ with .Labels }}{{ range $.Groups }}{{ end }}{{ end }}", ""},
+		{"nested range", "{{ range .Labels }}{{ range $.Vars }}{{ end }}{{ end }}", ""},
+		{"range over number", "{{ range 300000000 }}{{ end }}", "range only works over"},
+		{"range over variable", "{{ $n := 300000000 }}{{ range $n }}{{ end }}", "range only works over"},
+		{"range over dot", "{{ with .HostID }}{{ range . }}{{ end }}{{ end }}", "range only works over"},
+		{"range over scalar field", "{{ range .HostID }}{{ end }}", "range only works over"},
+		{"range over pipeline", "{{ range .Groups | join \",\" }}{{ end }}", "range only works over"},
+		{"range budget called directly", "{{ rangeLimit .Groups }}", "not defined"},
+		{"template call", `{{ define "a" }}x{{ end }}{{ template "a" }}`, "calling templates"},
+		{"block", `{{ block "a" . }}x{{ end }}`, "calling templates"},
+		{"unknown function", "{{ env \"HOME\" }}", "not defined"},
+		{"syntax error on line", "#!ipxe\n{{ if }}", "line 2:"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseScript(tt.script)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidScript) || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("got %v, want an invalid script error containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestRenderScript(t *testing.T) {
+	vars := ScriptVars{
+		Hostname: "pc01",
+		Groups:   []string{"lab", "staff"},
+		Labels:   map[string]string{"room": "B12"},
+		Vars:     map[string]string{"mirror": "10.0.0.1"},
+	}
+
+	tests := []struct {
+		name   string
+		script string
+		want   string
+		err    string
+	}{
+		{"fields", "{{ .Hostname }} {{ .Labels.room }} {{ .Vars.mirror }}", "pc01 B12 10.0.0.1", ""},
+		{"missing label", "[{{ .Labels.desk }}]", "[]", ""},
+		{"functions", `{{ .Hostname | upper }} {{ join "," .Groups }} {{ .Labels.desk | default "none" }}`, "PC01 lab,staff none", ""},
+		{"ipxe settings left alone", "chain http://${next-server}/", "chain http://${next-server}/", ""},
+		{"execution error", "#!ipxe\n{{ index .Groups 5 }}", "", "line 2:"},
+		{"too large", `{{ range .Labels }}{{ . | printf "%01048577s" }}{{ end }}`, "", "larger than"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RenderScript(tt.script, vars)
+			if tt.err != "" {
+				if !errors.Is(err, ErrInvalidScript) || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got %v, want an invalid script error containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// Scripts that write nothing must still stop once they have ranged over
+// maxRangeSteps items, however many labels the host has.
+func TestRenderScriptRangeLimit(t *testing.T) {
+	labels := map[string]string{}
+	for i := range 1000 {
+		labels[strconv.Itoa(i)] = "x"
+	}
+	vars := ScriptVars{Labels: labels, Vars: labels, Groups: []string{"a", "b"}}
+
+	tests := []struct {
+		name   string
+		script string
+		err    bool
+	}{
+		{"within the limit", strings.Repeat("{{ range .Labels }}{{ end }}", 100), false},
+		{"nested within the limit", "{{ range .Groups }}{{ range $.Labels }}{{ end }}{{ end }}", false},
+		{"nested past the limit", "{{ range .Labels }}{{ range $.Vars }}{{ end }}{{ end }}", true},
+		{"many past the limit", strings.Repeat("{{ range .Labels }}{{ end }}", 101), true},
+		{"in else past the limit", "{{ range .Labels }}{{ range $.Vars }}{{ end }}{{ else }}{{ end }}", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			_, err := RenderScript(tt.script, vars)
+			if tt.err && (!errors.Is(err, ErrInvalidScript) || !strings.Contains(err.Error(), "more than")) {
+				t.Fatalf("got %v, want the range limit error", err)
+			} else if !tt.err && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > maxRenderTime/2 {
+				t.Fatalf("rendering took %s", elapsed)
+			}
+		})
+	}
+}
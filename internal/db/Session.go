@@ -0,0 +1,90 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type Session struct {
+	gorm.Model
+	TokenHash string `gorm:"uniqueIndex"`
+	UserID    uint
+	User      User
+	ExpiresAt time.Time
+}
+
+var ErrSessionExpired = errors.New("session expired")
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// generateToken returns a random hex token. Only its hash is ever stored.
+func generateToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+// CreateSession starts a session for the user and returns the token to hand
+// back to the browser.
+func CreateSession(userID uint, ttl time.Duration, db *gorm.DB) (string, error) {
+	token, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+
+	ctx := context.Background()
+
+	err = gorm.G[Session](db).Create(ctx, &Session{
+		TokenHash: hashToken(token),
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func GetSessionUser(token string, db *gorm.DB) (*User, error) {
+	ctx := context.Background()
+
+	session, err := gorm.G[Session](db).Where("token_hash = ?", hashToken(token)).Preload("User", nil).First(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(session.ExpiresAt) {
+		gorm.G[Session](db).Where("id = ?", session.ID).Delete(ctx)
+		return nil, ErrSessionExpired
+	}
+
+	if session.User.ID == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	return &session.User, nil
+}
+
+func DeleteSession(token string, db *gorm.DB) error {
+	ctx := context.Background()
+
+	_, err := gorm.G[Session](db).Where("token_hash = ?", hashToken(token)).Delete(ctx)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
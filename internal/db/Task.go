@@ -13,43 +13,77 @@ type Task struct {
 	Script string `gorm:"type:longtext"`
 }
 
-func CreateTask(name, script string, db *gorm.DB) error {
-	ctx := context.Background()
+// CreateTask creates a task. The script must pass ValidateScript. author
+// and comment are recorded with its first revision.
+func CreateTask(name, script, author, comment string, db *gorm.DB) (*Task, error) {
+	if name == "" {
+		return nil, ErrEmptyName
+	}
+	if err := ValidateScript(script); err != nil {
+		return nil, err
+	}
 
-	err := gorm.G[Task](db).Create(ctx, &Task{Name: name, Script: script})
+	task := Task{Name: name, Script: script}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := gorm.G[Task](tx).Create(context.Background(), &task); err != nil {
+			return err
+		}
+		return addTaskRevision(task.ID, script, author, comment, tx)
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return &task, nil
 }
 
-func EditTask(name, script, id string, db *gorm.DB) error {
-	ctx := context.Background()
-
-	_, err := gorm.G[Task](db).Where("id = ?", id).Updates(ctx, Task{Name: name, Script: script})
-	if err != nil {
+// EditTask updates a task. The script must pass ValidateScript. A changed
+// script is recorded as a new revision by author, with comment.
+func EditTask(name, script, id, author, comment string, db *gorm.DB) error {
+	if err := ValidateScript(script); err != nil {
 		return err
 	}
 
-	return nil
+	ctx := context.Background()
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		task, err := gorm.G[Task](tx).Where("id = ?", id).First(ctx)
+		if err != nil {
+			return err
+		}
+
+		_, err = gorm.G[Task](tx).Where("id = ?", id).Updates(ctx, Task{Name: name, Script: script})
+		if err != nil {
+			return err
+		}
+
+		return addTaskRevision(task.ID, script, author, comment, tx)
+	})
 }
 
+// DeleteTask soft-deletes a task. Its revisions are kept along with it.
 func DeleteTask(id string, db *gorm.DB) error {
 	ctx := context.Background()
 
 	_, err := gorm.G[Task](db).Where("id = ?", id).Delete(ctx)
+	return err
+}
+
+func GetTaskByID(id string, db *gorm.DB) (*Task, error) {
+	ctx := context.Background()
+
+	task, err := gorm.G[Task](db).Where("id = ?", id).First(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return &task, nil
 }
 
-func GetTaskByID(id string, db *gorm.DB) (*Task, error) {
+func GetTaskByName(name string, db *gorm.DB) (*Task, error) {
 	ctx := context.Background()
 
-	task, err := gorm.G[Task](db).Where("id = ?", id).First(ctx)
+	task, err := gorm.G[Task](db).Where("name = ?", name).First(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -67,3 +101,26 @@ func GetTasks(db *gorm.DB) ([]Task, error) {
 
 	return tasks, nil
 }
+
+// ListTasks returns a page of tasks whose name contains name, and the total
+// number of matches.
+func ListTasks(name string, opts ListOptions, db *gorm.DB) ([]Task, int64, error) {
+	ctx := context.Background()
+
+	query := gorm.G[Task](db).Scopes()
+	if name != "" {
+		query = query.Where("LOWER(name) LIKE LOWER(?)", "%"+name+"%")
+	}
+
+	total, err := query.Count(ctx, "ID")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	tasks, err := query.Order("id").Offset(opts.Offset).Limit(opts.Limit).Find(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return tasks, total, nil
+}
@@ -0,0 +1,231 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TaskRevision is a saved version of a task's script. Saving a task with a
+// changed script adds one, numbered from 1 for each task, and revisions
+// are never changed afterwards.
+type TaskRevision struct {
+	ID        uint   `gorm:"primarykey"`
+	TaskID    int    `gorm:"uniqueIndex:idx_task_revision"`
+	Number    int    `gorm:"uniqueIndex:idx_task_revision"`
+	Script    string `gorm:"type:longtext"`
+	Author    string
+	Comment   string
+	CreatedAt time.Time
+}
+
+var ErrNoSuchRevision = errors.New("task has no such revision")
+
+// addTaskRevision records script as the task's newest revision, unless it
+// is the same as the newest already.
+func addTaskRevision(taskID int, script, author, comment string, db *gorm.DB) error {
+	ctx := context.Background()
+
+	number := 1
+	latest, err := gorm.G[TaskRevision](db).Where("task_id = ?", taskID).Order("number DESC").First(ctx)
+	if err == nil {
+		if latest.Script == script {
+			return nil
+		}
+		number = latest.Number + 1
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	return gorm.G[TaskRevision](db).Create(ctx, &TaskRevision{
+		TaskID:  taskID,
+		Number:  number,
+		Script:  script,
+		Author:  author,
+		Comment: comment,
+	})
+}
+
+// GetTaskRevisions returns a task's revisions, newest first.
+func GetTaskRevisions(taskID int, db *gorm.DB) ([]TaskRevision, error) {
+	ctx := context.Background()
+
+	return gorm.G[TaskRevision](db).Where("task_id = ?", taskID).Order("number DESC").Find(ctx)
+}
+
+// ListTaskRevisions returns a page of a task's revisions, newest first, and
+// how many it has.
+func ListTaskRevisions(taskID int, opts ListOptions, db *gorm.DB) ([]TaskRevision, int64, error) {
+	ctx := context.Background()
+
+	query := gorm.G[TaskRevision](db).Where("task_id = ?", taskID)
+
+	total, err := query.Count(ctx, "ID")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	revisions, err := query.Order("number DESC").Offset(opts.Offset).Limit(opts.Limit).Find(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return revisions, total, nil
+}
+
+func GetTaskRevision(taskID, number int, db *gorm.DB) (*TaskRevision, error) {
+	ctx := context.Background()
+
+	revision, err := gorm.G[TaskRevision](db).Where("task_id = ? AND number = ?", taskID, number).First(ctx)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w %d", ErrNoSuchRevision, number)
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &revision, nil
+}
+
+// RollbackTask makes the script of revision number the task's script again,
+// as a new revision. Like a saved script, it must pass ValidateScript, which
+// may be stricter than when the revision was saved.
+func RollbackTask(taskID, number int, author, comment string, db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		revision, err := GetTaskRevision(taskID, number, tx)
+		if err != nil {
+			return err
+		}
+		if err := ValidateScript(revision.Script); err != nil {
+			return err
+		}
+
+		ctx := context.Background()
+		rows, err := gorm.G[Task](tx).Where("id = ?", taskID).Update(ctx, "script", revision.Script)
+		if err != nil {
+			return err
+		} else if rows == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		if comment == "" {
+			comment = fmt.Sprintf("Rolled back to revision %d", number)
+		}
+		return addTaskRevision(taskID, revision.Script, author, comment, tx)
+	})
+}
+
+// MigrateTaskRevisions gives tasks saved before revisions were kept their
+// current script as revision 1. Scripts that fail ValidateScript are still
+// recorded, since they are what the task serves, but are logged so they can
+// be fixed.
+func MigrateTaskRevisions(db *gorm.DB) error {
+	var tasks []Task
+	err := db.Where("id NOT IN (?)", db.Model(&TaskRevision{}).Select("task_id")).Find(&tasks).Error
+	if err != nil {
+		return err
+	}
+
+	for _, task := range tasks {
+		if err := ValidateScript(task.Script); err != nil {
+			log.Printf("task %s needs fixing: %v", task.Name, err)
+		}
+		if err := addTaskRevision(task.ID, task.Script, "", "Saved before revisions were kept", db); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DiffLine is a line of a diff between two scripts. Op is " " for a line
+// both have, "-" for one only the old script has and "+" for one only the
+// new script has. OldLine and NewLine are 0 where the line is missing.
+type DiffLine struct {
+	Op      string
+	Text    string
+	OldLine int
+	NewLine int
+}
+
+// maxDiffCells bounds the work DiffScripts does on the lines that differ.
+// Past it the changed block is shown as removed and added whole.
+const maxDiffCells = 1_000_000
+
+// DiffScripts compares two scripts line by line.
+func DiffScripts(old, new string) []DiffLine {
+	a := strings.Split(strings.TrimSuffix(old, "\n"), "\n")
+	b := strings.Split(strings.TrimSuffix(new, "\n"), "\n")
+
+	var diff []DiffLine
+	same := func(i, j int) {
+		diff = append(diff, DiffLine{Op: " ", Text: a[i], OldLine: i + 1, NewLine: j + 1})
+	}
+	removed := func(i int) { diff = append(diff, DiffLine{Op: "-", Text: a[i], OldLine: i + 1}) }
+	added := func(j int) { diff = append(diff, DiffLine{Op: "+", Text: b[j], NewLine: j + 1}) }
+
+	// Lines shared at the start and end need no comparing.
+	start := 0
+	for start < len(a) && start < len(b) && a[start] == b[start] {
+		start++
+	}
+	endA, endB := len(a), len(b)
+	for endA > start && endB > start && a[endA-1] == b[endB-1] {
+		endA--
+		endB--
+	}
+
+	for i := 0; i < start; i++ {
+		same(i, i)
+	}
+
+	n, m := endA-start, endB-start
+	if n*m > maxDiffCells {
+		for i := start; i < endA; i++ {
+			removed(i)
+		}
+		for j := start; j < endB; j++ {
+			added(j)
+		}
+	} else {
+		// lcs[i][j] is the longest common subsequence of the changed
+		// lines from i and j on.
+		lcs := make([][]int, n+1)
+		for i := range lcs {
+			lcs[i] = make([]int, m+1)
+		}
+		for i := n - 1; i >= 0; i-- {
+			for j := m - 1; j >= 0; j-- {
+				if a[start+i] == b[start+j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else {
+					lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+				}
+			}
+		}
+
+		i, j := 0, 0
+		for i < n || j < m {
+			switch {
+			case i < n && j < m && a[start+i] == b[start+j]:
+				same(start+i, start+j)
+				i++
+				j++
+			case i < n && (j == m || lcs[i+1][j] >= lcs[i][j+1]):
+				removed(start + i)
+				i++
+			default:
+				added(start + j)
+				j++
+			}
+		}
+	}
+
+	for i, j := endA, endB; i < len(a); i, j = i+1, j+1 {
+		same(i, j)
+	}
+	return diff
+}
@@ -0,0 +1,89 @@
+package db
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDiffScripts(t *testing.T) {
+	tests := []struct {
+		name string
+		old  string
+		new  string
+		want []DiffLine
+	}{
+		{"same", "a\nb\n", "a\nb", []DiffLine{
+			{" ", "a", 1, 1},
+			{" ", "b", 2, 2},
+		}},
+		{"line added", "a\nc", "a\nb\nc", []DiffLine{
+			{" ", "a", 1, 1},
+			{"+", "b", 0, 2},
+			{" ", "c", 2, 3},
+		}},
+		{"line removed", "a\nb\nc", "a\nc", []DiffLine{
+			{" ", "a", 1, 1},
+			{"-", "b", 2, 0},
+			{" ", "c", 3, 2},
+		}},
+		{"line changed", "a\nb\nc", "a\nx\nc", []DiffLine{
+			{" ", "a", 1, 1},
+			{"-", "b", 2, 0},
+			{"+", "x", 0, 2},
+			{" ", "c", 3, 3},
+		}},
+		{"common line in changed block", "x\na\ny", "p\na\nq", []DiffLine{
+			{"-", "x", 1, 0},
+			{"+", "p", 0, 1},
+			{" ", "a", 2, 2},
+			{"-", "y", 3, 0},
+			{"+", "q", 0, 3},
+		}},
+		{"lines moved", "a\nb\nc", "c\na\nb", []DiffLine{
+			{"+", "c", 0, 1},
+			{" ", "a", 1, 2},
+			{" ", "b", 2, 3},
+			{"-", "c", 3, 0},
+		}},
+		{"from empty", "", "a", []DiffLine{
+			{"-", "", 1, 0},
+			{"+", "a", 0, 1},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DiffScripts(tt.old, tt.new); !slices.Equal(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestDiffScriptsLarge checks that a changed block too large to compare is
+// shown as removed and added whole, keeping the lines around it.
+func TestDiffScriptsLarge(t *testing.T) {
+	var old, new []string
+	for i := range 1100 {
+		old = append(old, "old "+strconv.Itoa(i))
+		new = append(new, "new "+strconv.Itoa(i))
+	}
+	old = append([]string{"#!ipxe"}, append(old, "boot")...)
+	new = append([]string{"#!ipxe"}, append(new, "boot")...)
+
+	diff := DiffScripts(strings.Join(old, "\n"), strings.Join(new, "\n"))
+
+	var ops strings.Builder
+	for _, line := range diff {
+		ops.WriteString(line.Op)
+	}
+	want := " " + strings.Repeat("-", 1100) + strings.Repeat("+", 1100) + " "
+	if ops.String() != want {
+		t.Fatalf("got ops %q, want the old block removed then the new one added", ops.String())
+	}
+	if last := diff[len(diff)-1]; last != (DiffLine{" ", "boot", 1102, 1102}) {
+		t.Fatalf("last line is %v", last)
+	}
+}
@@ -0,0 +1,210 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	RoleAdmin    = "admin"
+	RoleOperator = "operator"
+	RoleViewer   = "viewer"
+)
+
+var Roles = []string{RoleAdmin, RoleOperator, RoleViewer}
+
+type User struct {
+	gorm.Model
+	Username     string `gorm:"unique"`
+	Name         string
+	PasswordHash string
+	Role         string
+}
+
+// HasRole reports whether the user has any of the given roles.
+func (u *User) HasRole(roles ...string) bool {
+	return slices.Contains(roles, u.Role)
+}
+
+var ErrInvalidCredentials = errors.New("invalid username or password")
+var ErrInvalidRole = errors.New("invalid role")
+var ErrLastAdmin = errors.New("at least one admin is required")
+
+func hashPassword(password string) (string, error) {
+	if password == "" {
+		return "", errors.New("password cannot be empty")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hash), nil
+}
+
+func CreateUser(username, name, password, role string, db *gorm.DB) error {
+	if username == "" {
+		return errors.New("username cannot be empty")
+	}
+	if !slices.Contains(Roles, role) {
+		return ErrInvalidRole
+	}
+	if name == "" {
+		name = username
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	err = gorm.G[User](db).Create(ctx, &User{Username: username, Name: name, PasswordHash: hash, Role: role})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// EditUser updates a user's display name and role, and their password if one
+// is given. The last admin cannot be demoted.
+func EditUser(name, password, role string, id uint, db *gorm.DB) error {
+	var user User
+
+	if err := db.First(&user, id).Error; err != nil {
+		return err
+	}
+
+	if role != "" && role != user.Role {
+		if !slices.Contains(Roles, role) {
+			return ErrInvalidRole
+		}
+		if user.Role == RoleAdmin {
+			if err := checkOtherAdmins(user.ID, db); err != nil {
+				return err
+			}
+		}
+		user.Role = role
+	}
+
+	if name != "" {
+		user.Name = name
+	}
+	if password != "" {
+		hash, err := hashPassword(password)
+		if err != nil {
+			return err
+		}
+		user.PasswordHash = hash
+	}
+
+	return db.Save(&user).Error
+}
+
+func checkOtherAdmins(id uint, db *gorm.DB) error {
+	ctx := context.Background()
+
+	count, err := gorm.G[User](db).Where("role = ? AND id <> ?", RoleAdmin, id).Count(ctx, "ID")
+	if err != nil {
+		return err
+	} else if count == 0 {
+		return ErrLastAdmin
+	}
+
+	return nil
+}
+
+func DeleteUser(id string, db *gorm.DB) error {
+	ctx := context.Background()
+
+	user, err := GetUserByID(id, db)
+	if err != nil {
+		return err
+	}
+	if user.Role == RoleAdmin {
+		if err := checkOtherAdmins(user.ID, db); err != nil {
+			return err
+		}
+	}
+
+	_, err = gorm.G[Session](db).Where("user_id = ?", id).Delete(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = gorm.G[APIToken](db).Where("user_id = ?", id).Delete(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = gorm.G[User](db).Where("id = ?", id).Delete(ctx)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func GetUserByID(id string, db *gorm.DB) (*User, error) {
+	ctx := context.Background()
+
+	user, err := gorm.G[User](db).Where("id = ?", id).First(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func GetUsers(db *gorm.DB) ([]User, error) {
+	ctx := context.Background()
+
+	users, err := gorm.G[User](db).Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+func GetUserCount(db *gorm.DB) (int64, error) {
+	ctx := context.Background()
+
+	return gorm.G[User](db).Count(ctx, "ID")
+}
+
+// AuthenticateUser returns the user matching username and password, or
+// ErrInvalidCredentials if either is wrong.
+func AuthenticateUser(username, password string, db *gorm.DB) (*User, error) {
+	ctx := context.Background()
+
+	user, err := gorm.G[User](db).Where("username = ?", username).First(ctx)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidCredentials
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &user, nil
+}
+
+// MigrateUserRoles gives users created before roles existed the admin role,
+// since they previously had full access.
+func MigrateUserRoles(db *gorm.DB) error {
+	ctx := context.Background()
+
+	_, err := gorm.G[User](db).Where("role IS NULL OR role = ''").Update(ctx, "role", RoleAdmin)
+
+	return err
+}
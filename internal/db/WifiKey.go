@@ -13,23 +13,39 @@ type WifiKey struct {
 	Key string `gorm:"uniqueIndex"`
 }
 
-func CreateWifiKey(key string, db *gorm.DB) error {
+var ErrEmptyKey = errors.New("key cannot be empty")
+
+func CreateWifiKey(key string, db *gorm.DB) (*WifiKey, error) {
+	if key == "" {
+		return nil, ErrEmptyKey
+	}
+
 	ctx := context.Background()
 
-	err := gorm.G[WifiKey](db).Create(ctx, &WifiKey{Key: key})
+	wifiKey := WifiKey{Key: key}
+	err := gorm.G[WifiKey](db).Create(ctx, &wifiKey)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return &wifiKey, nil
 }
 
 func EditWifiKey(key string, id uint, db *gorm.DB) error {
+	if key == "" {
+		return ErrEmptyKey
+	}
+
 	ctx := context.Background()
 
-	_, err := gorm.G[WifiKey](db).Update(ctx, "key", key)
+	rows, err := gorm.G[WifiKey](db).Where("id = ?", id).Update(ctx, "key", key)
+	if err != nil {
+		return err
+	} else if rows == 0 {
+		return gorm.ErrRecordNotFound
+	}
 
-	return err
+	return nil
 }
 
 func DeleteWifiKey(id uint, db *gorm.DB) error {
@@ -113,3 +129,46 @@ func GetOrAssignWifiKeyToHost(hostID uint, db *gorm.DB) (*WifiKey, error) {
 
 	return key, nil
 }
+
+// GetUsedWifiKeyIDs returns the set of wifi key IDs assigned to a host.
+func GetUsedWifiKeyIDs(db *gorm.DB) (map[uint]bool, error) {
+	var ids []uint
+	if err := db.Model(&Host{}).Where("wifi_key_id IS NOT NULL").Pluck("wifi_key_id", &ids).Error; err != nil {
+		return nil, err
+	}
+
+	used := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		used[id] = true
+	}
+
+	return used, nil
+}
+
+// ListWifiKeys returns a page of wifi keys, optionally only those that are or
+// are not assigned to a host, and the total number of matches.
+func ListWifiKeys(used *bool, opts ListOptions, db *gorm.DB) ([]WifiKey, int64, error) {
+	ctx := context.Background()
+
+	query := gorm.G[WifiKey](db).Scopes()
+	if used != nil {
+		assigned := db.Model(&Host{}).Select("wifi_key_id").Where("wifi_key_id IS NOT NULL")
+		if *used {
+			query = query.Where("id IN (?)", assigned)
+		} else {
+			query = query.Where("id NOT IN (?)", assigned)
+		}
+	}
+
+	total, err := query.Count(ctx, "ID")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	keys, err := query.Order("id").Offset(opts.Offset).Limit(opts.Limit).Find(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return keys, total, nil
+}
@@ -0,0 +1,158 @@
+package dnsmasq
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"pxehub/internal/netboot"
+)
+
+// LeaseScriptCommand is the pxehub subcommand dnsmasq runs, through a
+// generated wrapper script, whenever a lease changes.
+const LeaseScriptCommand = "dhcp-script"
+
+// reportedArches are tagged in the dnsmasq config whether or not they have a
+// boot file, so leases record them.
+var reportedArches = []uint16{0, 1, 6, 7, 9, 10, 11}
+
+// scopeTag returns the dnsmasq tag set for clients in the named scope.
+func scopeTag(name string) string {
+	return "scope-" + name
+}
+
+// archTag returns the dnsmasq tag set for clients with option 93 arch.
+func archTag(arch uint16) string {
+	return fmt.Sprintf("arch%d", arch)
+}
+
+func (d *DnsmasqServer) leaseScriptPath() string {
+	return d.runtimePath("pxehub-dhcp-script")
+}
+
+// shellQuote quotes s for use as a single word in a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// startLeaseListener listens for lease events from the dhcp-script and writes
+// the wrapper dnsmasq runs. It returns the path of the wrapper.
+func (d *DnsmasqServer) startLeaseListener() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to find pxehub executable: %w", err)
+	}
+
+	d.leaseSocket = d.runtimePath("pxehub-leases.sock")
+	scriptPath := d.leaseScriptPath()
+
+	os.Remove(d.leaseSocket)
+	d.leaseListener, err = net.Listen("unix", d.leaseSocket)
+	if err != nil {
+		return "", fmt.Errorf("failed to listen for leases: %w", err)
+	}
+
+	script := fmt.Sprintf("#!/bin/sh\nexec %s %s %s \"$@\"\n",
+		shellQuote(exe), LeaseScriptCommand, shellQuote(d.leaseSocket))
+	if err := os.WriteFile(scriptPath, []byte(script), 0755); err != nil {
+		d.leaseListener.Close()
+		return "", err
+	}
+
+	go func() {
+		for {
+			conn, err := d.leaseListener.Accept()
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				log.Printf("lease listener error: %v", err)
+				continue
+			}
+
+			var lease netboot.Lease
+			err = json.NewDecoder(conn).Decode(&lease)
+			conn.Close()
+			if err != nil {
+				log.Printf("bad lease from dhcp-script: %v", err)
+				continue
+			}
+
+			d.OnLease(lease)
+		}
+	}()
+
+	return scriptPath, nil
+}
+
+func (d *DnsmasqServer) stopLeaseListener() {
+	if d.leaseListener == nil {
+		return
+	}
+
+	d.leaseListener.Close()
+	os.Remove(d.leaseSocket)
+	os.Remove(d.leaseScriptPath())
+}
+
+// RunLeaseScript handles a dnsmasq dhcp-script call and forwards the lease to
+// the running pxehub. args are the socket path followed by dnsmasq's own
+// arguments: the action, MAC, IP and optionally the hostname.
+func RunLeaseScript(args []string) error {
+	if len(args) < 2 {
+		return errors.New("usage: " + LeaseScriptCommand + " <socket> <action> [mac ip [hostname]]")
+	}
+
+	socket, action := args[0], args[1]
+	if action != "add" && action != "old" && action != "del" {
+		// init and tftp calls carry no lease.
+		return nil
+	}
+	if len(args) < 4 {
+		return fmt.Errorf("%s: missing mac or ip", action)
+	}
+
+	lease := netboot.Lease{
+		Mac:         args[2],
+		IP:          args[3],
+		VendorClass: os.Getenv("DNSMASQ_VENDOR_CLASS"),
+		ClientArch:  -1,
+		Expires:     time.Now(),
+	}
+	if len(args) > 4 {
+		lease.Hostname = args[4]
+	}
+
+	for _, tag := range strings.Fields(os.Getenv("DNSMASQ_TAGS")) {
+		if name, ok := strings.CutPrefix(tag, scopeTag("")); ok {
+			lease.Scope = name
+		}
+		if num, ok := strings.CutPrefix(tag, "arch"); ok {
+			if arch, err := strconv.ParseUint(num, 10, 16); err == nil {
+				lease.ClientArch = int(arch)
+			}
+		}
+	}
+
+	if action != "del" {
+		if expires, err := strconv.ParseInt(os.Getenv("DNSMASQ_LEASE_EXPIRES"), 10, 64); err == nil {
+			lease.Expires = time.Unix(expires, 0)
+		} else if length, err := strconv.ParseInt(os.Getenv("DNSMASQ_LEASE_LENGTH"), 10, 64); err == nil {
+			lease.Expires = time.Now().Add(time.Duration(length) * time.Second)
+		}
+	}
+
+	conn, err := net.DialTimeout("unix", socket, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return json.NewEncoder(conn).Encode(lease)
+}
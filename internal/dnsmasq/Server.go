@@ -1,129 +1,232 @@
 package dnsmasq
 
 import (
-	"bufio"
+	"errors"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
+	"maps"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"pxehub/internal/ipxe"
+	"pxehub/internal/netboot"
+	"slices"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
 type DnsmasqServer struct {
-	Iface       string
-	RangeStart  string
-	RangeEnd    string
-	Mask        string
-	Router      string
-	Nameservers []string
-	TFTPDir     string
-	ConfigPath  string
-	cmd         *exec.Cmd
+	// Scopes are rendered into one config, each tagged with its name.
+	Scopes  []netboot.Scope
+	TFTPDir string
+	IPXE    ipxe.Options
+	// BootFiles maps client architectures to boot files in TFTPDir.
+	BootFiles map[uint16]string
+	// Proxy only supplies boot information to clients on each scope's
+	// ProxySubnet, leaving addresses to the network's own DHCP server.
+	// The ranges, routers and nameservers are not used.
+	Proxy bool
+	// RuntimeDir holds the generated config, hosts file, lease socket and
+	// dnsmasq's own lease and pid files. It defaults to os.TempDir().
+	RuntimeDir string
+	ConfigPath string
+	OnLease    func(netboot.Lease)
+
+	leaseSocket   string
+	leaseListener net.Listener
+
+	// mu guards the fields below, which the supervisor changes as it
+	// restarts dnsmasq.
+	mu           sync.Mutex
+	cmd          *exec.Cmd
+	stop         chan struct{}
+	done         chan struct{}
+	status       netboot.Status
+	reservations []netboot.Reservation
 }
 
-func downloadFile(url, dest string) error {
-	resp, err := http.Get(url)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	out, err := os.Create(dest)
-	if err != nil {
-		return err
+// runtimePath returns the path of a file dnsmasq or pxehub writes at
+// runtime.
+func (d *DnsmasqServer) runtimePath(name string) string {
+	dir := d.RuntimeDir
+	if dir == "" {
+		dir = os.TempDir()
 	}
-	defer out.Close()
+	return filepath.Join(dir, name)
+}
 
-	_, err = io.Copy(out, resp.Body)
-	return err
+func (d *DnsmasqServer) hostsFilePath() string {
+	return d.runtimePath("pxehub-dhcp-hosts")
 }
 
-func (d *DnsmasqServer) prepareTFTP() error {
-	binaries := map[string]string{
-		"ipxe.pxe": "https://boot.ipxe.org/ipxe.pxe",
-		"ipxe.efi": "https://boot.ipxe.org/ipxe.efi",
-	}
+// writeHostsFile renders the reservations as a dhcp-hostsfile, one
+// dhcp-host entry per line.
+func (d *DnsmasqServer) writeHostsFile() error {
+	var hosts strings.Builder
 
-	for name, url := range binaries {
-		dest := filepath.Join(d.TFTPDir, name)
-		log.Printf("Downloading %s...", name)
-		if err := downloadFile(url, dest); err != nil {
-			return fmt.Errorf("failed to download %s: %w", name, err)
+	for _, r := range d.reservations {
+		fields := []string{r.Mac}
+		if r.IP != "" {
+			fields = append(fields, r.IP)
+		}
+		if r.Hostname != "" {
+			fields = append(fields, r.Hostname)
 		}
-		if err := os.Chmod(dest, 0755); err != nil {
-			return fmt.Errorf("failed to chmod %s: %w", name, err)
+		if len(fields) > 1 {
+			hosts.WriteString(strings.Join(fields, ",") + "\n")
 		}
 	}
 
-	script := `#!ipxe
-dhcp
-chain --autofree http://${next-server}/api/boot/${net0/mac}
-	`
+	return os.WriteFile(d.hostsFilePath(), []byte(hosts.String()), 0644)
+}
+
+// SetReservations replaces the static reservations. If dnsmasq is running it
+// is sent SIGHUP, which makes it re-read the hosts file.
+func (d *DnsmasqServer) SetReservations(reservations []netboot.Reservation) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 
-	scriptPath := filepath.Join(d.TFTPDir, "autoexec.ipxe")
+	d.reservations = reservations
 
-	if err := os.WriteFile(scriptPath, []byte(script), 0644); err != nil {
+	if err := d.writeHostsFile(); err != nil {
 		return err
 	}
 
+	if d.cmd != nil && d.cmd.Process != nil {
+		err := d.cmd.Process.Signal(syscall.SIGHUP)
+		if errors.Is(err, os.ErrProcessDone) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to reload dnsmasq: %w", err)
+		}
+		log.Printf("Reloaded dnsmasq with %d reservations", len(reservations))
+	}
+
 	return nil
 }
 
-func (d *DnsmasqServer) generateConfig() (string, error) {
+// SetLeases does nothing, since dnsmasq keeps its leases in its own lease
+// file.
+func (d *DnsmasqServer) SetLeases(leases []netboot.Lease) error {
+	return nil
+}
+
+// renderConfig returns the dnsmasq configuration. leaseScript is the
+// dhcp-script to run on lease changes, or "" for none.
+func (d *DnsmasqServer) renderConfig(leaseScript string) string {
 	var opts strings.Builder
 
-	if d.Router != "" {
-		opts.WriteString(fmt.Sprintf("dhcp-option=3,%s\n", d.Router))
+	if leaseScript != "" {
+		opts.WriteString(fmt.Sprintf("dhcp-script=%s\n", leaseScript))
+	}
+
+	var ifaces, nameservers []string
+	var scopes strings.Builder
+	for _, scope := range d.Scopes {
+		if !slices.Contains(ifaces, scope.Iface) {
+			ifaces = append(ifaces, scope.Iface)
+		}
+
+		tag := scopeTag(scope.Name)
+		scopes.WriteString(fmt.Sprintf("# Scope %s\n", scope.Name))
+
+		// In proxy mode the network's own DHCP server hands out the
+		// addresses, router and nameservers.
+		if d.Proxy {
+			scopes.WriteString(fmt.Sprintf("dhcp-range=set:%s,%s,proxy,%s\n", tag, scope.ProxySubnet, scope.Mask))
+			continue
+		}
+		scopes.WriteString(fmt.Sprintf("dhcp-range=set:%s,%s,%s,%s,12h\n", tag, scope.RangeStart, scope.RangeEnd, scope.Mask))
+		if scope.Router != "" {
+			scopes.WriteString(fmt.Sprintf("dhcp-option=tag:%s,3,%s\n", tag, scope.Router))
+		}
+		if len(scope.Nameservers) > 0 {
+			scopes.WriteString(fmt.Sprintf("dhcp-option=tag:%s,6,%s\n", tag, strings.Join(scope.Nameservers, ",")))
+			for _, ns := range scope.Nameservers {
+				if !slices.Contains(nameservers, ns) {
+					nameservers = append(nameservers, ns)
+				}
+			}
+		}
+	}
+
+	var ifaceLines strings.Builder
+	for _, iface := range ifaces {
+		ifaceLines.WriteString(fmt.Sprintf("interface=%s\n", iface))
 	}
 
-	if len(d.Nameservers) > 0 {
-		opts.WriteString(fmt.Sprintf("dhcp-option=6,%s\n", strings.Join(d.Nameservers, ",")))
+	if len(nameservers) > 0 {
 		opts.WriteString("no-resolv\n")
-		for _, ns := range d.Nameservers {
+		for _, ns := range nameservers {
 			opts.WriteString(fmt.Sprintf("server=%s\n", ns))
 		}
 	}
 
+	// Tag every known architecture, even without a boot file, so leases
+	// report it.
+	arches := append(slices.Collect(maps.Keys(d.BootFiles)), reportedArches...)
+	slices.Sort(arches)
+	arches = slices.Compact(arches)
+
+	var boot strings.Builder
+	boot.WriteString("# PXE client architecture matching\n")
+	for _, arch := range arches {
+		boot.WriteString(fmt.Sprintf("dhcp-match=set:%s, option:client-arch, %d\n", archTag(arch), arch))
+	}
+
+	boot.WriteString("\n# iPXE override\n")
+	boot.WriteString("dhcp-userclass=set:ipxe,iPXE\n")
+
+	boot.WriteString("\n# Assign boot files based on architecture\n")
+	if d.Proxy {
+		// Proxy DHCP clients get their boot file through PXE services,
+		// one per architecture. A prompt timeout of 0 boots it straight
+		// away instead of showing a menu.
+		boot.WriteString("pxe-prompt=\"pxehub\",0\n")
+		for _, arch := range arches {
+			if file, ok := d.BootFiles[arch]; ok {
+				boot.WriteString(fmt.Sprintf("pxe-service=tag:!ipxe,%d,\"pxehub\",%s\n", arch, file))
+				boot.WriteString(fmt.Sprintf("pxe-service=tag:ipxe,%d,\"pxehub\",%s\n", arch, ipxe.ChainScript))
+			}
+		}
+	} else {
+		for _, arch := range arches {
+			if file, ok := d.BootFiles[arch]; ok {
+				boot.WriteString(fmt.Sprintf("dhcp-boot=tag:%s,tag:!ipxe,%s\n", archTag(arch), file))
+			}
+		}
+		boot.WriteString(fmt.Sprintf("dhcp-boot=tag:ipxe,%s\n", ipxe.ChainScript))
+	}
+
 	confTemplate := `
-interface=%s
-bind-interfaces
+%sbind-interfaces
 port=0
-dhcp-range=%s,%s,%s,12h
 enable-tftp
 tftp-root=%s
+dhcp-hostsfile=%s
+dhcp-leasefile=%s
+pid-file=%s
 
-# PXE client architecture matching
-dhcp-match=set:archx86, option:client-arch, 0
-dhcp-match=set:archx64, option:client-arch, 6
-dhcp-match=set:archx32, option:client-arch, 7
-dhcp-match=set:archia32, option:client-arch, 1
-
-# Assign boot files based on architecture
-dhcp-boot=tag:archx86,ipxe.pxe
-dhcp-boot=tag:archx64,ipxe.efi
-dhcp-boot=tag:archx32,ipxe.efi
-dhcp-boot=tag:archia32,ipxe.pxe
-
-# iPXE override
-dhcp-userclass=set:ipxe,iPXE
-dhcp-boot=tag:ipxe,autoexec.ipxe
-
+%s
+%s
 %s
 
 log-dhcp
 `
 
-	conf := fmt.Sprintf(confTemplate,
-		d.Iface, d.RangeStart, d.RangeEnd,
-		d.Mask, d.TFTPDir, opts.String(),
+	return fmt.Sprintf(confTemplate,
+		ifaceLines.String(), d.TFTPDir, d.hostsFilePath(), d.runtimePath("dnsmasq.leases"), d.runtimePath("dnsmasq.pid"), scopes.String(), boot.String(), opts.String(),
 	)
+}
+
+func (d *DnsmasqServer) generateConfig(leaseScript string) (string, error) {
+	conf := d.renderConfig(leaseScript)
 
-	tmpDir := os.TempDir()
-	confPath := filepath.Join(tmpDir, "dnsmasq.conf")
+	confPath := d.runtimePath("dnsmasq.conf")
 
 	if err := os.WriteFile(confPath, []byte(conf), 0644); err != nil {
 		return "", err
@@ -132,98 +235,132 @@ log-dhcp
 	return confPath, nil
 }
 
-func (d *DnsmasqServer) Start() error {
-	if err := d.prepareTFTP(); err != nil {
-		return err
-	}
-
+// Test checks the configuration with dnsmasq --test, without touching a
+// running dnsmasq.
+func (d *DnsmasqServer) Test() error {
 	path, err := exec.LookPath("dnsmasq")
 	if err != nil {
 		return fmt.Errorf("dnsmasq not found in PATH: %w", err)
 	}
 
-	confPath, err := d.generateConfig()
+	var leaseScript string
+	if d.OnLease != nil {
+		leaseScript = d.leaseScriptPath()
+	}
+
+	file, err := os.CreateTemp("", "dnsmasq-test-*.conf")
 	if err != nil {
 		return err
 	}
+	defer os.Remove(file.Name())
 
-	d.cmd = exec.Command(path, "--no-daemon", "--conf-file="+confPath)
+	_, err = file.WriteString(d.renderConfig(leaseScript))
+	file.Close()
+	if err != nil {
+		return err
+	}
 
-	stdoutPipe, err := d.cmd.StdoutPipe()
+	out, err := exec.Command(path, "--test", "--conf-file="+file.Name()).CombinedOutput()
 	if err != nil {
-		return fmt.Errorf("failed to get stdout pipe: %w", err)
+		return fmt.Errorf("dnsmasq rejected the configuration: %s", strings.TrimSpace(string(out)))
 	}
-	stderrPipe, err := d.cmd.StderrPipe()
+
+	return nil
+}
+
+func (d *DnsmasqServer) Start() error {
+	if err := ipxe.PrepareTFTP(d.TFTPDir, d.BootFiles, d.IPXE); err != nil {
+		return err
+	}
+
+	path, err := exec.LookPath("dnsmasq")
 	if err != nil {
-		return fmt.Errorf("failed to get stderr pipe: %w", err)
+		return fmt.Errorf("dnsmasq not found in PATH: %w", err)
 	}
 
-	go func() {
-		scanner := bufio.NewScanner(stdoutPipe)
-		for scanner.Scan() {
-			log.Print(scanner.Text())
-		}
-		if err := scanner.Err(); err != nil && err != io.EOF {
-			log.Printf("error reading dnsmasq stdout: %v", err)
+	var leaseScript string
+	if d.OnLease != nil {
+		leaseScript, err = d.startLeaseListener()
+		if err != nil {
+			return err
 		}
-	}()
+	}
 
-	go func() {
-		scanner := bufio.NewScanner(stderrPipe)
-		for scanner.Scan() {
-			log.Print(scanner.Text())
-		}
-		if err := scanner.Err(); err != nil && err != io.EOF {
-			log.Printf("error reading dnsmasq stderr: %v", err)
-		}
-	}()
+	d.mu.Lock()
+	err = d.writeHostsFile()
+	d.mu.Unlock()
+	if err != nil {
+		d.stopLeaseListener()
+		return err
+	}
 
-	go func() {
-		if err := d.cmd.Start(); err != nil {
-			log.Printf("Failed to start dnsmasq: %v", err)
-			return
-		}
+	confPath, err := d.generateConfig(leaseScript)
+	if err != nil {
+		d.stopLeaseListener()
+		return err
+	}
+
+	d.mu.Lock()
+	d.stop = make(chan struct{})
+	d.done = make(chan struct{})
+	d.status = netboot.Status{Server: "dnsmasq"}
+	err = d.spawn(path, confPath)
+	if err != nil {
+		d.stop, d.done = nil, nil
+		d.status.State = netboot.StateFailed
+	}
+	d.mu.Unlock()
+	if err != nil {
+		d.stopLeaseListener()
+		return err
+	}
 
+	for _, scope := range d.Scopes {
 		log.Printf(
-			"Starting dnsmasq [pid %d] on iface %s serving %s-%s (router %s, nameservers %v, TFTPDir %s)",
-			d.cmd.Process.Pid, d.Iface, d.RangeStart, d.RangeEnd, d.Router, d.Nameservers, d.TFTPDir,
+			"dnsmasq scope %s on iface %s serving %s-%s (router %s, nameservers %v)",
+			scope.Name, scope.Iface, scope.RangeStart, scope.RangeEnd, scope.Router, scope.Nameservers,
 		)
+	}
 
-		if err := d.cmd.Wait(); err != nil {
-			if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 5 {
-			} else {
-				log.Printf("dnsmasq exited with error: %v", err)
-			}
-		} else {
-			log.Printf("dnsmasq exited cleanly")
-		}
-	}()
+	go d.supervise(path, confPath)
 
 	return nil
 }
 
 func (d *DnsmasqServer) Stop() error {
-	if d.cmd != nil && d.cmd.Process != nil {
-		if err := d.cmd.Process.Signal(os.Interrupt); err != nil {
-			log.Printf("Failed to send interrupt: %v", err)
+	d.mu.Lock()
+	stop, done, cmd := d.stop, d.done, d.cmd
+	if stop != nil {
+		select {
+		case <-stop:
+		default:
+			close(stop)
 		}
+	}
+	d.mu.Unlock()
 
-		done := make(chan error, 1)
-		go func() {
-			done <- d.cmd.Wait()
-		}()
+	if stop != nil {
+		if cmd != nil && cmd.Process != nil {
+			if err := cmd.Process.Signal(os.Interrupt); err != nil && !errors.Is(err, os.ErrProcessDone) {
+				log.Printf("Failed to send interrupt: %v", err)
+			}
+		}
 
 		select {
 		case <-done:
 
 		case <-time.After(3 * time.Second):
 			log.Printf("dnsmasq did not exit, killing...")
-			if err := d.cmd.Process.Kill(); err != nil {
+			if err := cmd.Process.Kill(); err != nil {
 				log.Printf("Failed to kill dnsmasq: %v", err)
 			}
+			<-done
 		}
 	}
 
+	d.stopLeaseListener()
+	os.Remove(d.hostsFilePath())
+
 	if d.ConfigPath != "" {
 		if err := os.Remove(d.ConfigPath); err == nil {
 			log.Printf("Deleted config: %s", d.ConfigPath)
@@ -0,0 +1,178 @@
+package dnsmasq
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"time"
+
+	"pxehub/internal/netboot"
+)
+
+const (
+	// restartDelay is the wait before the first restart, doubling with each
+	// further crash up to maxRestartDelay.
+	restartDelay    = time.Second
+	maxRestartDelay = 2 * time.Minute
+
+	// stableRunTime is how long dnsmasq must run before earlier crashes are
+	// forgiven.
+	stableRunTime = time.Minute
+
+	// maxFailures is how many crashes in a row are retried before giving up.
+	maxFailures = 10
+)
+
+var errStopping = errors.New("dnsmasq is stopping")
+
+// logWriter logs each line dnsmasq writes.
+type logWriter struct {
+	buf []byte
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		log.Print(string(w.buf[:i]))
+		w.buf = w.buf[i+1:]
+	}
+}
+
+// spawn starts a dnsmasq process. Callers must hold d.mu.
+func (d *DnsmasqServer) spawn(path, confPath string) error {
+	select {
+	case <-d.stop:
+		return errStopping
+	default:
+	}
+
+	cmd := exec.Command(path, "--no-daemon", "--conf-file="+confPath)
+	cmd.Stdout = &logWriter{}
+	cmd.Stderr = &logWriter{}
+
+	if err := cmd.Start(); err != nil {
+		d.status.LastError = err.Error()
+		return fmt.Errorf("failed to start dnsmasq: %w", err)
+	}
+
+	log.Printf(
+		"Starting dnsmasq [pid %d] serving %d scopes (TFTPDir %s)",
+		cmd.Process.Pid, len(d.Scopes), d.TFTPDir,
+	)
+
+	d.cmd = cmd
+	d.status.State = netboot.StateRunning
+	d.status.PID = cmd.Process.Pid
+	d.status.StartedAt = time.Now()
+
+	return nil
+}
+
+func backoff(failures int) time.Duration {
+	delay := restartDelay
+	for i := 1; i < failures && delay < maxRestartDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRestartDelay)
+}
+
+// supervise waits for dnsmasq to exit and restarts it with exponential
+// backoff, until Stop is called or it has failed maxFailures times in a row.
+func (d *DnsmasqServer) supervise(path, confPath string) {
+	defer close(d.done)
+
+	failures := 0
+	for {
+		d.mu.Lock()
+		cmd := d.cmd
+		startedAt := d.status.StartedAt
+		d.mu.Unlock()
+
+		err := cmd.Wait()
+		exitCode := cmd.ProcessState.ExitCode()
+
+		d.mu.Lock()
+		d.status.PID = 0
+		select {
+		case <-d.stop:
+			d.status.State = netboot.StateStopped
+			d.mu.Unlock()
+			log.Printf("dnsmasq exited cleanly")
+			return
+		default:
+		}
+
+		if err == nil {
+			err = errors.New("exit status 0")
+		}
+		log.Printf("dnsmasq exited unexpectedly: %v", err)
+
+		if time.Since(startedAt) >= stableRunTime {
+			failures = 0
+		}
+		failures++
+		d.status.Crashes++
+		d.status.LastExitCode = &exitCode
+		d.status.LastError = err.Error()
+		d.mu.Unlock()
+
+		for {
+			if failures > maxFailures {
+				d.mu.Lock()
+				d.status.State = netboot.StateFailed
+				d.mu.Unlock()
+				log.Printf("dnsmasq failed %d times in a row, giving up", maxFailures)
+				return
+			}
+
+			delay := backoff(failures)
+			d.mu.Lock()
+			d.status.State = netboot.StateRestarting
+			d.mu.Unlock()
+			log.Printf("Restarting dnsmasq in %s", delay)
+
+			select {
+			case <-d.stop:
+				d.mu.Lock()
+				d.status.State = netboot.StateStopped
+				d.mu.Unlock()
+				return
+			case <-time.After(delay):
+			}
+
+			d.mu.Lock()
+			err := d.spawn(path, confPath)
+			d.mu.Unlock()
+			if err == nil {
+				break
+			}
+			if errors.Is(err, errStopping) {
+				d.mu.Lock()
+				d.status.State = netboot.StateStopped
+				d.mu.Unlock()
+				return
+			}
+
+			log.Print(err)
+			failures++
+		}
+	}
+}
+
+func (d *DnsmasqServer) Status() netboot.Status {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	status := d.status
+	status.Server = "dnsmasq"
+	if status.State == "" {
+		status.State = netboot.StateStopped
+	}
+	return status
+}
@@ -0,0 +1,106 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"pxehub/internal/db"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+)
+
+// renderTokens shows the token list. created is the plain text of a token
+// that was just made, shown once since only its hash is kept.
+func (h *HttpServer) renderTokens(w http.ResponseWriter, r *http.Request, created string) {
+	w.Header().Set("Content-Type", "text/html")
+	caser := cases.Title(language.English)
+	user := currentUser(r)
+
+	tmpl, err := parseTemplates("base.html", "tokens.html")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tokens, err := db.GetAPITokens(h.Database)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	path := r.URL.Path
+	if created != "" {
+		path = "/tokens"
+	}
+
+	data := map[string]any{
+		"Title":       caser.String("api tokens"),
+		"Name":        user.Name,
+		"Path":        path,
+		"CurrentUser": user,
+		"Tokens":      tokens,
+		"Scopes":      db.Scopes,
+		"Created":     created,
+	}
+
+	if err := tmpl.ExecuteTemplate(w, "base", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (h *HttpServer) NewAPIToken(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	name := r.FormValue("tokenName")
+	scope := r.FormValue("tokenScope")
+	expiry := r.FormValue("tokenExpiry")
+	redirect := r.FormValue("redirect") == "true"
+
+	if name == "" || scope == "" {
+		http.Error(w, "Missing fields", http.StatusBadRequest)
+		return
+	}
+
+	var expiresAt *time.Time
+	if expiry != "" {
+		t, err := time.ParseInLocation("2006-01-02", expiry, time.Local)
+		if err != nil {
+			http.Error(w, "Invalid expiry date", http.StatusBadRequest)
+			return
+		}
+		// The token works until the end of the chosen day.
+		t = t.AddDate(0, 0, 1).Add(-time.Second)
+		expiresAt = &t
+	}
+
+	token, err := db.CreateAPIToken(name, scope, expiresAt, currentUser(r).ID, h.Database)
+	if err != nil {
+		http.Error(w, "Create failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if redirect {
+		h.renderTokens(w, r, token)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok", "token": token})
+	}
+}
+
+func (h *HttpServer) DeleteAPIToken(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+
+	redirect := r.FormValue("redirect") == "true"
+
+	if err := db.DeleteAPIToken(id, h.Database); err != nil {
+		http.Error(w, "Update failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if redirect {
+		http.Redirect(w, r, "/tokens", http.StatusSeeOther)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"ok"}`))
+	}
+}
@@ -0,0 +1,105 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"pxehub/internal/db"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"gorm.io/gorm"
+)
+
+const defaultPerPage = 50
+const maxPerPage = 500
+
+type apiErrorBody struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type apiError struct {
+	Error apiErrorBody `json:"error"`
+}
+
+type apiList[T any] struct {
+	Items   []T   `json:"items"`
+	Page    int   `json:"page"`
+	PerPage int   `json:"per_page"`
+	Total   int64 `json:"total"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeAPIError replies with a JSON error body, for example
+// {"error":{"status":404,"code":"not_found","message":"host not found"}}.
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	code := strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+	writeJSON(w, status, apiError{Error: apiErrorBody{Status: status, Code: code, Message: message}})
+}
+
+// writeDBError maps errors from the db package to an API status code.
+func writeDBError(w http.ResponseWriter, err error, what string) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		writeAPIError(w, http.StatusNotFound, what+" not found")
+	case errors.Is(err, db.ErrIPInUse):
+		writeAPIError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		writeAPIError(w, http.StatusConflict, what+" already exists")
+	case errors.Is(err, db.ErrInvalidMAC), errors.Is(err, db.ErrEmptyName), errors.Is(err, db.ErrEmptyKey),
+		errors.Is(err, db.ErrInvalidIP), errors.Is(err, db.ErrOutsideSubnet), errors.Is(err, db.ErrInvalidHostname),
+		errors.Is(err, db.ErrInvalidLabel), errors.Is(err, db.ErrInvalidScript), errors.Is(err, db.ErrNoSuchRevision),
+		errors.Is(err, db.ErrNoTaskToPin):
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// readJSON decodes the request body into v, rejecting unknown fields.
+func readJSON(r *http.Request, v any) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("invalid JSON body: %w", err)
+	}
+	return nil
+}
+
+// parsePage reads the page and per_page query parameters.
+func parsePage(r *http.Request) (opts db.ListOptions, page, perPage int, err error) {
+	page, perPage = 1, defaultPerPage
+
+	if val := r.URL.Query().Get("page"); val != "" {
+		page, err = strconv.Atoi(val)
+		if err != nil || page < 1 {
+			return opts, 0, 0, errors.New("page must be a positive integer")
+		}
+	}
+	if val := r.URL.Query().Get("per_page"); val != "" {
+		perPage, err = strconv.Atoi(val)
+		if err != nil || perPage < 1 || perPage > maxPerPage {
+			return opts, 0, 0, fmt.Errorf("per_page must be between 1 and %d", maxPerPage)
+		}
+	}
+
+	opts = db.ListOptions{Offset: (page - 1) * perPage, Limit: perPage}
+	return opts, page, perPage, nil
+}
+
+func parseID(ps httprouter.Params) (uint, error) {
+	id, err := strconv.ParseUint(ps.ByName("id"), 10, 0)
+	if err != nil {
+		return 0, errors.New("id must be a positive integer")
+	}
+	return uint(id), nil
+}
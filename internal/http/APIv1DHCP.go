@@ -0,0 +1,63 @@
+package httpserver
+
+import (
+	"net/http"
+	"pxehub/internal/netboot"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type dhcpStatusJSON struct {
+	Server        string     `json:"server"`
+	State         string     `json:"state"`
+	PID           int        `json:"pid,omitempty"`
+	StartedAt     *time.Time `json:"started_at"`
+	UptimeSeconds int64      `json:"uptime_seconds"`
+	Crashes       int        `json:"crashes"`
+	LastExitCode  *int       `json:"last_exit_code"`
+	LastError     string     `json:"last_error,omitempty"`
+}
+
+func toDHCPStatusJSON(status netboot.Status) dhcpStatusJSON {
+	out := dhcpStatusJSON{
+		Server:       status.Server,
+		State:        status.State,
+		PID:          status.PID,
+		Crashes:      status.Crashes,
+		LastExitCode: status.LastExitCode,
+		LastError:    status.LastError,
+	}
+	if status.State == netboot.StateRunning {
+		out.StartedAt = &status.StartedAt
+		out.UptimeSeconds = int64(time.Since(status.StartedAt).Seconds())
+	}
+	return out
+}
+
+func (h *HttpServer) dhcpStatus() netboot.Status {
+	if h.DHCPStatus == nil {
+		return netboot.Status{State: netboot.StateStopped}
+	}
+	return h.DHCPStatus()
+}
+
+func (h *HttpServer) GetDHCPStatusV1(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	writeJSON(w, http.StatusOK, toDHCPStatusJSON(h.dhcpStatus()))
+}
+
+// ReloadDHCPV1 reloads the DHCP and TFTP server from pxehub.conf. A
+// configuration dnsmasq rejects leaves the running server untouched.
+func (h *HttpServer) ReloadDHCPV1(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if h.ReloadDHCP == nil {
+		writeAPIError(w, http.StatusServiceUnavailable, "dhcp reload is not available")
+		return
+	}
+
+	if err := h.ReloadDHCP(); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "reloaded"})
+}
@@ -0,0 +1,335 @@
+package httpserver
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"pxehub/internal/db"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"gorm.io/gorm"
+)
+
+type hostJSON struct {
+	ID             uint              `json:"id"`
+	Name           string            `json:"name"`
+	Mac            string            `json:"mac"`
+	TaskID         *int              `json:"task_id"`
+	PermanentTask  bool              `json:"permanent_task"`
+	PinnedRevision *int              `json:"pinned_revision"`
+	WifiKeyID      *uint             `json:"wifi_key_id"`
+	ReservedIP     *string           `json:"reserved_ip"`
+	DHCPHostname   string            `json:"dhcp_hostname"`
+	Labels         map[string]string `json:"labels"`
+	CreatedAt      time.Time         `json:"created_at"`
+	UpdatedAt      time.Time         `json:"updated_at"`
+}
+
+// hostRequest is the body of POST and PUT. Fields left out of a PUT keep
+// their current value, a task_id of 0 clears the task, a pinned_revision of
+// 0 boots the latest revision, an empty reserved_ip clears the reservation
+// and labels replaces every label.
+type hostRequest struct {
+	Name           *string            `json:"name"`
+	Mac            *string            `json:"mac"`
+	TaskID         *int               `json:"task_id"`
+	PermanentTask  *bool              `json:"permanent_task"`
+	PinnedRevision *int               `json:"pinned_revision"`
+	ReservedIP     *string            `json:"reserved_ip"`
+	DHCPHostname   *string            `json:"dhcp_hostname"`
+	Labels         *map[string]string `json:"labels"`
+}
+
+// bootPreviewJSON is the boot script a host would get, and the scope it was
+// rendered for. Warning is set if a changed boot menu failed and the
+// built-in one was used instead.
+type bootPreviewJSON struct {
+	Scope   string `json:"scope"`
+	Script  string `json:"script"`
+	Warning string `json:"warning,omitempty"`
+}
+
+func toHostJSON(host *db.Host) hostJSON {
+	return hostJSON{
+		ID:             host.ID,
+		Name:           host.Name,
+		Mac:            host.Mac,
+		TaskID:         host.TaskID,
+		PermanentTask:  host.PermanentTask,
+		PinnedRevision: host.PinnedRevision,
+		WifiKeyID:      host.WifiKeyID,
+		ReservedIP:     host.ReservedIP,
+		DHCPHostname:   host.DHCPHostname,
+		Labels:         host.LabelMap(),
+		CreatedAt:      host.CreatedAt,
+		UpdatedAt:      host.UpdatedAt,
+	}
+}
+
+// checkTaskExists reports a 400 if taskID refers to a missing task.
+func (h *HttpServer) checkTaskExists(w http.ResponseWriter, taskID *int) bool {
+	if taskID == nil || *taskID == 0 {
+		return true
+	}
+	if _, err := db.GetTaskByID(strconv.Itoa(*taskID), h.Database); err != nil {
+		writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("task %d does not exist", *taskID))
+		return false
+	}
+	return true
+}
+
+// setPinnedRevision pins a host to a revision of its task, or unpins it for
+// a revision of 0. A nil revision leaves the pin as it is.
+func setPinnedRevision(revision *int, hostID uint, tx *gorm.DB) error {
+	if revision == nil {
+		return nil
+	}
+	if *revision == 0 {
+		return db.SetHostPinnedRevision(nil, hostID, tx)
+	}
+	return db.SetHostPinnedRevision(revision, hostID, tx)
+}
+
+func (h *HttpServer) ListHostsV1(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	opts, page, perPage, err := parsePage(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	filter := db.HostFilter{
+		Name: r.URL.Query().Get("name"),
+		Mac:  r.URL.Query().Get("mac"),
+	}
+	if val := r.URL.Query().Get("task_id"); val != "" {
+		taskID, err := strconv.Atoi(val)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "task_id must be an integer")
+			return
+		}
+		filter.TaskID = &taskID
+	}
+	if values := r.URL.Query()["label"]; len(values) > 0 {
+		labels, err := db.ParseLabelFilter(strings.Join(values, ","))
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		filter.Labels = labels
+	}
+
+	hosts, total, err := db.ListHosts(filter, opts, h.Database)
+	if err != nil {
+		writeDBError(w, err, "host")
+		return
+	}
+
+	items := make([]hostJSON, 0, len(hosts))
+	for i := range hosts {
+		items = append(items, toHostJSON(&hosts[i]))
+	}
+
+	writeJSON(w, http.StatusOK, apiList[hostJSON]{Items: items, Page: page, PerPage: perPage, Total: total})
+}
+
+func (h *HttpServer) GetHostV1(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := parseID(ps)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	host, err := db.GetHostByID(strconv.Itoa(int(id)), h.Database)
+	if err != nil {
+		writeDBError(w, err, "host")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toHostJSON(host))
+}
+
+func (h *HttpServer) CreateHostV1(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var req hostRequest
+	if err := readJSON(r, &req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.Name == nil || req.Mac == nil {
+		writeAPIError(w, http.StatusBadRequest, "name and mac are required")
+		return
+	}
+	if !h.checkTaskExists(w, req.TaskID) {
+		return
+	}
+
+	var taskID int
+	if req.TaskID != nil {
+		taskID = *req.TaskID
+	}
+	permanent := req.PermanentTask != nil && *req.PermanentTask
+
+	var reservedIP, dhcpHostname string
+	if req.ReservedIP != nil {
+		reservedIP = *req.ReservedIP
+	}
+	if req.DHCPHostname != nil {
+		dhcpHostname = *req.DHCPHostname
+	}
+
+	var host *db.Host
+	err := h.Database.Transaction(func(tx *gorm.DB) error {
+		created, err := db.CreateHost(*req.Mac, *req.Name, taskID, permanent, tx)
+		if err != nil {
+			return err
+		}
+		if err := db.SetHostReservation(reservedIP, dhcpHostname, h.subnets(), created.ID, tx); err != nil {
+			return err
+		}
+		if err := setPinnedRevision(req.PinnedRevision, created.ID, tx); err != nil {
+			return err
+		}
+		if req.Labels != nil {
+			if err := db.SetHostLabels(*req.Labels, created.ID, tx); err != nil {
+				return err
+			}
+		}
+		host, err = db.GetHostByID(strconv.Itoa(int(created.ID)), tx)
+		return err
+	})
+	if err != nil {
+		writeDBError(w, err, "host")
+		return
+	}
+	h.hostsChanged()
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/hosts/%d", host.ID))
+	writeJSON(w, http.StatusCreated, toHostJSON(host))
+}
+
+func (h *HttpServer) UpdateHostV1(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := parseID(ps)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	host, err := db.GetHostByID(strconv.Itoa(int(id)), h.Database)
+	if err != nil {
+		writeDBError(w, err, "host")
+		return
+	}
+
+	var req hostRequest
+	if err := readJSON(r, &req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !h.checkTaskExists(w, req.TaskID) {
+		return
+	}
+
+	if req.Name != nil {
+		host.Name = *req.Name
+	}
+	if req.Mac != nil {
+		host.Mac = *req.Mac
+	}
+	if req.TaskID != nil {
+		host.TaskID = req.TaskID
+	}
+	if req.PermanentTask != nil {
+		host.PermanentTask = *req.PermanentTask
+	}
+	var reservedIP string
+	if req.ReservedIP != nil {
+		reservedIP = *req.ReservedIP
+	} else if host.ReservedIP != nil {
+		reservedIP = *host.ReservedIP
+	}
+	if req.DHCPHostname != nil {
+		host.DHCPHostname = *req.DHCPHostname
+	}
+
+	err = h.Database.Transaction(func(tx *gorm.DB) error {
+		if err := db.EditHost(host.Name, host.Mac, host.TaskID, host.PermanentTask, host.ID, tx); err != nil {
+			return err
+		}
+		if err := setPinnedRevision(req.PinnedRevision, host.ID, tx); err != nil {
+			return err
+		}
+		if req.Labels != nil {
+			if err := db.SetHostLabels(*req.Labels, host.ID, tx); err != nil {
+				return err
+			}
+		}
+		return db.SetHostReservation(reservedIP, host.DHCPHostname, h.subnets(), host.ID, tx)
+	})
+	if err != nil {
+		writeDBError(w, err, "host")
+		return
+	}
+	h.hostsChanged()
+
+	host, err = db.GetHostByID(strconv.Itoa(int(id)), h.Database)
+	if err != nil {
+		writeDBError(w, err, "host")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toHostJSON(host))
+}
+
+func (h *HttpServer) DeleteHostV1(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := parseID(ps)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if _, err := db.GetHostByID(strconv.Itoa(int(id)), h.Database); err != nil {
+		writeDBError(w, err, "host")
+		return
+	}
+
+	if err := db.DeleteHost(strconv.Itoa(int(id)), h.Database); err != nil {
+		writeDBError(w, err, "host")
+		return
+	}
+	h.hostsChanged()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PreviewHostV1 replies with the boot script a host would get, without
+// logging a request or using up its task.
+func (h *HttpServer) PreviewHostV1(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := parseID(ps)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	host, err := db.GetHostByID(strconv.Itoa(int(id)), h.Database)
+	if err != nil {
+		writeDBError(w, err, "host")
+		return
+	}
+
+	script, warning, scope, err := h.previewScript(host.Mac, r.URL.Query().Get("scope"), r.Host)
+	if errors.Is(err, db.ErrInvalidScript) {
+		writeAPIError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	} else if errors.Is(err, errUnknownScope) {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	} else if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, bootPreviewJSON{Scope: scope.Name, Script: script, Warning: warning})
+}
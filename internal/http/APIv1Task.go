@@ -0,0 +1,275 @@
+package httpserver
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"pxehub/internal/db"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type taskJSON struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Script    string    `json:"script"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// taskRequest is the body of POST and PUT. Fields left out of a PUT keep
+// their current value. comment is recorded with the revision a changed
+// script makes.
+type taskRequest struct {
+	Name    *string `json:"name"`
+	Script  *string `json:"script"`
+	Comment string  `json:"comment"`
+}
+
+type taskRevisionJSON struct {
+	Number    int       `json:"number"`
+	Script    string    `json:"script"`
+	Author    string    `json:"author"`
+	Comment   string    `json:"comment"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// rollbackRequest is the body of a rollback. Without a comment the new
+// revision says which one it rolled back to.
+type rollbackRequest struct {
+	Revision int    `json:"revision"`
+	Comment  string `json:"comment"`
+}
+
+func toTaskRevisionJSON(revision *db.TaskRevision) taskRevisionJSON {
+	return taskRevisionJSON{
+		Number:    revision.Number,
+		Script:    revision.Script,
+		Author:    revision.Author,
+		Comment:   revision.Comment,
+		CreatedAt: revision.CreatedAt,
+	}
+}
+
+func toTaskJSON(task *db.Task) taskJSON {
+	return taskJSON{
+		ID:        task.ID,
+		Name:      task.Name,
+		Script:    task.Script,
+		CreatedAt: task.CreatedAt,
+		UpdatedAt: task.UpdatedAt,
+	}
+}
+
+func (h *HttpServer) ListTasksV1(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	opts, page, perPage, err := parsePage(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tasks, total, err := db.ListTasks(r.URL.Query().Get("name"), opts, h.Database)
+	if err != nil {
+		writeDBError(w, err, "task")
+		return
+	}
+
+	items := make([]taskJSON, 0, len(tasks))
+	for i := range tasks {
+		items = append(items, toTaskJSON(&tasks[i]))
+	}
+
+	writeJSON(w, http.StatusOK, apiList[taskJSON]{Items: items, Page: page, PerPage: perPage, Total: total})
+}
+
+func (h *HttpServer) GetTaskV1(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := parseID(ps)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	task, err := db.GetTaskByID(strconv.Itoa(int(id)), h.Database)
+	if err != nil {
+		writeDBError(w, err, "task")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toTaskJSON(task))
+}
+
+func (h *HttpServer) CreateTaskV1(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var req taskRequest
+	if err := readJSON(r, &req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.Name == nil || req.Script == nil || *req.Script == "" {
+		writeAPIError(w, http.StatusBadRequest, "name and script are required")
+		return
+	}
+
+	task, err := db.CreateTask(*req.Name, *req.Script, author(r), req.Comment, h.Database)
+	if err != nil {
+		writeDBError(w, err, "task")
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/tasks/%d", task.ID))
+	writeJSON(w, http.StatusCreated, toTaskJSON(task))
+}
+
+func (h *HttpServer) UpdateTaskV1(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := parseID(ps)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	task, err := db.GetTaskByID(strconv.Itoa(int(id)), h.Database)
+	if err != nil {
+		writeDBError(w, err, "task")
+		return
+	}
+
+	var req taskRequest
+	if err := readJSON(r, &req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.Name != nil {
+		if *req.Name == "" {
+			writeAPIError(w, http.StatusBadRequest, db.ErrEmptyName.Error())
+			return
+		}
+		task.Name = *req.Name
+	}
+	if req.Script != nil {
+		if *req.Script == "" {
+			writeAPIError(w, http.StatusBadRequest, "script cannot be empty")
+			return
+		}
+		task.Script = *req.Script
+	}
+
+	if err := db.EditTask(task.Name, task.Script, strconv.Itoa(task.ID), author(r), req.Comment, h.Database); err != nil {
+		writeDBError(w, err, "task")
+		return
+	}
+
+	task, err = db.GetTaskByID(strconv.Itoa(int(id)), h.Database)
+	if err != nil {
+		writeDBError(w, err, "task")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toTaskJSON(task))
+}
+
+func (h *HttpServer) DeleteTaskV1(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := parseID(ps)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if _, err := db.GetTaskByID(strconv.Itoa(int(id)), h.Database); err != nil {
+		writeDBError(w, err, "task")
+		return
+	}
+
+	if err := db.DeleteTask(strconv.Itoa(int(id)), h.Database); err != nil {
+		writeDBError(w, err, "task")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *HttpServer) ListTaskRevisionsV1(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := parseID(ps)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	opts, page, perPage, err := parsePage(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if _, err := db.GetTaskByID(strconv.Itoa(int(id)), h.Database); err != nil {
+		writeDBError(w, err, "task")
+		return
+	}
+
+	revisions, total, err := db.ListTaskRevisions(int(id), opts, h.Database)
+	if err != nil {
+		writeDBError(w, err, "task")
+		return
+	}
+
+	items := make([]taskRevisionJSON, 0, len(revisions))
+	for i := range revisions {
+		items = append(items, toTaskRevisionJSON(&revisions[i]))
+	}
+
+	writeJSON(w, http.StatusOK, apiList[taskRevisionJSON]{Items: items, Page: page, PerPage: perPage, Total: total})
+}
+
+func (h *HttpServer) GetTaskRevisionV1(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := parseID(ps)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	number, err := strconv.Atoi(ps.ByName("number"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid revision number")
+		return
+	}
+
+	revision, err := db.GetTaskRevision(int(id), number, h.Database)
+	if errors.Is(err, db.ErrNoSuchRevision) {
+		writeAPIError(w, http.StatusNotFound, "revision not found")
+		return
+	} else if err != nil {
+		writeDBError(w, err, "revision")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toTaskRevisionJSON(revision))
+}
+
+func (h *HttpServer) RollbackTaskV1(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := parseID(ps)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var req rollbackRequest
+	if err := readJSON(r, &req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := db.RollbackTask(int(id), req.Revision, author(r), req.Comment, h.Database); err != nil {
+		writeDBError(w, err, "task")
+		return
+	}
+
+	task, err := db.GetTaskByID(strconv.Itoa(int(id)), h.Database)
+	if err != nil {
+		writeDBError(w, err, "task")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toTaskJSON(task))
+}
@@ -0,0 +1,169 @@
+package httpserver
+
+import (
+	"fmt"
+	"net/http"
+	"pxehub/internal/db"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type wifiKeyJSON struct {
+	ID        uint      `json:"id"`
+	Key       string    `json:"key"`
+	Used      bool      `json:"used"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type wifiKeyRequest struct {
+	Key *string `json:"key"`
+}
+
+func toWifiKeyJSON(key *db.WifiKey, used map[uint]bool) wifiKeyJSON {
+	return wifiKeyJSON{
+		ID:        key.ID,
+		Key:       key.Key,
+		Used:      used[key.ID],
+		CreatedAt: key.CreatedAt,
+		UpdatedAt: key.UpdatedAt,
+	}
+}
+
+func (h *HttpServer) ListWifiKeysV1(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	opts, page, perPage, err := parsePage(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var usedFilter *bool
+	if val := r.URL.Query().Get("used"); val != "" {
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "used must be true or false")
+			return
+		}
+		usedFilter = &b
+	}
+
+	keys, total, err := db.ListWifiKeys(usedFilter, opts, h.Database)
+	if err != nil {
+		writeDBError(w, err, "wifi key")
+		return
+	}
+
+	used, err := db.GetUsedWifiKeyIDs(h.Database)
+	if err != nil {
+		writeDBError(w, err, "wifi key")
+		return
+	}
+
+	items := make([]wifiKeyJSON, 0, len(keys))
+	for i := range keys {
+		items = append(items, toWifiKeyJSON(&keys[i], used))
+	}
+
+	writeJSON(w, http.StatusOK, apiList[wifiKeyJSON]{Items: items, Page: page, PerPage: perPage, Total: total})
+}
+
+func (h *HttpServer) GetWifiKeyV1(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := parseID(ps)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	key, err := db.GetWifiKeyByID(strconv.Itoa(int(id)), h.Database)
+	if err != nil {
+		writeDBError(w, err, "wifi key")
+		return
+	}
+
+	used, err := db.GetUsedWifiKeyIDs(h.Database)
+	if err != nil {
+		writeDBError(w, err, "wifi key")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toWifiKeyJSON(key, used))
+}
+
+func (h *HttpServer) CreateWifiKeyV1(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var req wifiKeyRequest
+	if err := readJSON(r, &req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.Key == nil {
+		writeAPIError(w, http.StatusBadRequest, "key is required")
+		return
+	}
+
+	key, err := db.CreateWifiKey(*req.Key, h.Database)
+	if err != nil {
+		writeDBError(w, err, "wifi key")
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/wifikeys/%d", key.ID))
+	writeJSON(w, http.StatusCreated, toWifiKeyJSON(key, nil))
+}
+
+func (h *HttpServer) UpdateWifiKeyV1(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := parseID(ps)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var req wifiKeyRequest
+	if err := readJSON(r, &req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.Key == nil {
+		writeAPIError(w, http.StatusBadRequest, "key is required")
+		return
+	}
+
+	if err := db.EditWifiKey(*req.Key, id, h.Database); err != nil {
+		writeDBError(w, err, "wifi key")
+		return
+	}
+
+	h.GetWifiKeyV1(w, r, ps)
+}
+
+func (h *HttpServer) DeleteWifiKeyV1(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := parseID(ps)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if _, err := db.GetWifiKeyByID(strconv.Itoa(int(id)), h.Database); err != nil {
+		writeDBError(w, err, "wifi key")
+		return
+	}
+
+	used, err := db.GetUsedWifiKeyIDs(h.Database)
+	if err != nil {
+		writeDBError(w, err, "wifi key")
+		return
+	} else if used[id] {
+		writeAPIError(w, http.StatusConflict, "wifi key is assigned to a host")
+		return
+	}
+
+	if err := db.DeleteWifiKey(id, h.Database); err != nil {
+		writeDBError(w, err, "wifi key")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,237 @@
+package httpserver
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"pxehub/internal/db"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const sessionCookieName = "pxehub_session"
+const sessionTTL = 7 * 24 * time.Hour
+
+type contextKey string
+
+const userContextKey contextKey = "user"
+const tokenContextKey contextKey = "token"
+
+// currentUser returns the logged in user or, for requests made with an API
+// token, the user who created it. requireAPI has already checked that user
+// still has a role the route allows.
+func currentUser(r *http.Request) *db.User {
+	if token, _ := r.Context().Value(tokenContextKey).(*db.APIToken); token != nil {
+		return &token.User
+	}
+	user, _ := r.Context().Value(userContextKey).(*db.User)
+	if user == nil {
+		return &db.User{}
+	}
+	return user
+}
+
+// author names who made a request, for records such as task revisions: the
+// logged in user, or the API token and the user who created it.
+func author(r *http.Request) string {
+	if token, _ := r.Context().Value(tokenContextKey).(*db.APIToken); token != nil {
+		if token.User.Username == "" {
+			return "token " + token.Name
+		}
+		return token.User.Username + " (token " + token.Name + ")"
+	}
+	return currentUser(r).Username
+}
+
+// authError replies to a request that failed authentication or
+// authorisation, using the JSON error body on the versioned API.
+func authError(w http.ResponseWriter, r *http.Request, status int) {
+	if strings.HasPrefix(r.URL.Path, "/api/v1/") {
+		writeAPIError(w, status, http.StatusText(status))
+		return
+	}
+	http.Error(w, http.StatusText(status), status)
+}
+
+// requireAuth rejects requests without a valid session. Browsers are sent to
+// the login page, API callers get a 401.
+func (h *HttpServer) requireAuth(next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		var user *db.User
+		if cookie, err := r.Cookie(sessionCookieName); err == nil {
+			user, _ = db.GetSessionUser(cookie.Value, h.Database)
+		}
+
+		if user == nil {
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				authError(w, r, http.StatusUnauthorized)
+			} else {
+				http.Redirect(w, r, "/login", http.StatusSeeOther)
+			}
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), userContextKey, user)
+		next(w, r.WithContext(ctx), ps)
+	}
+}
+
+// requireRole is requireAuth plus a check that the user holds one of roles.
+func (h *HttpServer) requireRole(next httprouter.Handle, roles ...string) httprouter.Handle {
+	return h.requireAuth(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if !currentUser(r).HasRole(roles...) {
+			authError(w, r, http.StatusForbidden)
+			return
+		}
+
+		next(w, r, ps)
+	})
+}
+
+// requireAPI is requireRole for API routes, additionally accepting an
+// "Authorization: Bearer" token whose scope covers the route. The token's
+// creator must still have one of roles.
+func (h *HttpServer) requireAPI(next httprouter.Handle, scope string, roles ...string) httprouter.Handle {
+	withSession := h.requireRole(next, roles...)
+
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		header := r.Header.Get("Authorization")
+		if !strings.HasPrefix(header, "Bearer ") {
+			withSession(w, r, ps)
+			return
+		}
+
+		apiToken, err := db.UseAPIToken(strings.TrimPrefix(header, "Bearer "), h.Database)
+		if err != nil {
+			authError(w, r, http.StatusUnauthorized)
+			return
+		}
+
+		if !apiToken.Allows(scope, r.Method) || !apiToken.User.HasRole(roles...) {
+			authError(w, r, http.StatusForbidden)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), tokenContextKey, apiToken)
+		next(w, r.WithContext(ctx), ps)
+	}
+}
+
+func (h *HttpServer) startSession(w http.ResponseWriter, user *db.User) error {
+	token, err := db.CreateSession(user.ID, sessionTTL, h.Database)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  time.Now().Add(sessionTTL),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return nil
+}
+
+func (h *HttpServer) Login(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	username := r.FormValue("username")
+	password := r.FormValue("password")
+
+	user, err := db.AuthenticateUser(username, password, h.Database)
+	if err != nil {
+		log.Printf("Failed login for %q from %s", username, r.RemoteAddr)
+		http.Redirect(w, r, "/login?error=1", http.StatusSeeOther)
+		return
+	}
+
+	if err := h.startSession(w, user); err != nil {
+		http.Error(w, "Login failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// Setup creates the first account as an admin. It is refused once any user
+// exists.
+func (h *HttpServer) Setup(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	count, err := db.GetUserCount(h.Database)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if count > 0 {
+		http.Error(w, "Setup already complete", http.StatusForbidden)
+		return
+	}
+
+	username := r.FormValue("username")
+	name := r.FormValue("name")
+	password := r.FormValue("password")
+
+	if err := db.CreateUser(username, name, password, db.RoleAdmin, h.Database); err != nil {
+		http.Error(w, "Setup failed: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, err := db.AuthenticateUser(username, password, h.Database)
+	if err != nil {
+		http.Error(w, "Setup failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.startSession(w, user); err != nil {
+		http.Error(w, "Login failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (h *HttpServer) Logout(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		if err := db.DeleteSession(cookie.Value, h.Database); err != nil {
+			log.Printf("Failed to delete session: %v", err)
+		}
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+func (h *HttpServer) LoginPage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "text/html")
+
+	tmpl, err := parseTemplates("login.html")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	count, err := db.GetUserCount(h.Database)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]any{
+		"Title": "Login",
+		"Setup": count == 0,
+		"Error": r.URL.Query().Get("error") != "",
+	}
+
+	if err := tmpl.ExecuteTemplate(w, "login", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,136 @@
+package httpserver
+
+import (
+	"errors"
+	"net/http"
+	"pxehub/internal/db"
+	"slices"
+
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+)
+
+// menuView is a boot menu as shown on the menus page. ScriptIssues,
+// ScriptErrors and Force are named for the scriptIssues and scriptForce
+// templates.
+type menuView struct {
+	*db.BootMenu
+	Custom       bool
+	Builtin      string
+	MenuError    string
+	ScriptIssues []db.ScriptIssue
+	ScriptErrors bool
+	Force        bool
+}
+
+func (h *HttpServer) EditBootMenu(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	name := ps.ByName("name")
+	script := r.FormValue("menuScript")
+	redirect := r.FormValue("redirect") == "true"
+
+	if !slices.Contains(db.Menus, name) {
+		http.Error(w, "Unknown menu", http.StatusNotFound)
+		return
+	}
+	if script == "" {
+		http.Error(w, "Missing fields", http.StatusBadRequest)
+		return
+	}
+
+	if !checkScript(w, r, script, redirect, func() { h.renderMenus(w, r, name, script, "") }) {
+		return
+	}
+
+	if err := db.SetBootMenu(name, script, author(r), h.Database); errors.Is(err, db.ErrInvalidScript) && redirect {
+		h.renderMenus(w, r, name, script, err.Error())
+		return
+	} else if errors.Is(err, db.ErrInvalidScript) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "Update failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if redirect {
+		http.Redirect(w, r, "/menus?menu="+name, http.StatusSeeOther)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"ok"}`))
+	}
+}
+
+func (h *HttpServer) ResetBootMenu(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	name := ps.ByName("name")
+	redirect := r.FormValue("redirect") == "true"
+
+	if err := db.ResetBootMenu(name, h.Database); errors.Is(err, db.ErrUnknownMenu) {
+		http.Error(w, "Unknown menu", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Update failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if redirect {
+		http.Redirect(w, r, "/menus?menu="+name, http.StatusSeeOther)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"ok"}`))
+	}
+}
+
+// renderMenus shows the boot menus page with the tab of active open. When a
+// menu could not be saved, posted is the script that was sent and menuErr
+// why it was rejected, if not for its iPXE errors or warnings.
+func (h *HttpServer) renderMenus(w http.ResponseWriter, r *http.Request, active, posted, menuErr string) {
+	user := currentUser(r)
+	caser := cases.Title(language.English)
+
+	if !slices.Contains(db.Menus, active) {
+		active = db.Menus[0]
+	}
+
+	tmpl, err := parseTemplates("base.html", "menus.html", "script_help.html")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var menus []menuView
+	for _, name := range db.Menus {
+		menu, custom, err := db.GetBootMenu(name, h.Database)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		builtin, _ := db.BuiltinMenu(name)
+
+		view := menuView{BootMenu: menu, Custom: custom, Builtin: builtin}
+		if name == active && posted != "" {
+			view.Script = posted
+			view.MenuError = menuErr
+			view.Force = r.FormValue("force") == "true"
+		}
+		view.ScriptIssues = db.CheckIPXEScript(view.Script)
+		view.ScriptErrors = db.HasScriptErrors(view.ScriptIssues)
+		menus = append(menus, view)
+	}
+
+	data := map[string]any{
+		"Title":       caser.String("boot menus"),
+		"Name":        user.Name,
+		"Path":        "/menus",
+		"CurrentUser": user,
+		"Menus":       menus,
+		"Active":      active,
+	}
+
+	if posted != "" {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	if err := tmpl.ExecuteTemplate(w, "base", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
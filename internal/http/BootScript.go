@@ -1,15 +1,26 @@
 package httpserver
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"pxehub/internal/db"
+	"pxehub/internal/netboot"
 	"regexp"
+	"slices"
+	"strings"
 
 	"github.com/julienschmidt/httprouter"
 )
 
+var scriptErrorScript = `#!ipxe
+echo Boot script error: %s
+prompt Press any key to exit
+exit
+`
+
 func (h *HttpServer) BootScript(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	w.Header().Set("Content-Type", "text/plain")
 	macRegex := regexp.MustCompile(`^([0-9A-Fa-f]{2}:){5}[0-9A-Fa-f]{2}$`)
@@ -19,8 +30,23 @@ func (h *HttpServer) BootScript(w http.ResponseWriter, r *http.Request, ps httpr
 		return
 	}
 
-	script, err := db.GetScriptByMAC(mac, h.Database, true)
-	if err != nil {
+	scope := h.scopeFor(r, mac)
+	clientIP, _, _ := net.SplitHostPort(r.RemoteAddr)
+	script, err := db.GetScriptByMAC(db.BootRequest{
+		MAC:         mac,
+		Scope:       scope.Name,
+		DefaultTask: scope.DefaultTask,
+		Server:      r.Host,
+		ClientIP:    clientIP,
+		Vars:        h.ScriptVars,
+	}, h.Database)
+	if errors.Is(err, db.ErrInvalidScript) {
+		// Tell whoever is at the machine instead of failing silently.
+		log.Printf("boot script for %s: %v", mac, err)
+		msg := strings.NewReplacer("${", "$ {", "\n", " ").Replace(err.Error())
+		fmt.Fprintf(w, scriptErrorScript, msg)
+		return
+	} else if err != nil {
 		fmt.Fprint(w, "Error")
 		log.Print("Error in http request", err)
 		return
@@ -28,3 +54,69 @@ func (h *HttpServer) BootScript(w http.ResponseWriter, r *http.Request, ps httpr
 
 	fmt.Fprint(w, script)
 }
+
+var errUnknownScope = errors.New("unknown scope")
+
+// previewScript returns the script GetScriptByMAC would serve mac if it booted
+// from the scope of its last lease, or from the named scope, without
+// changing anything. The request is taken to come from the address of the
+// last lease. It also returns the scope used, and a warning if a changed
+// boot menu failed and the built-in one was used, as it would be on boot.
+func (h *HttpServer) previewScript(mac, scopeName, server string) (string, string, netboot.Scope, error) {
+	scope := h.scopeForMAC(mac)
+	if scopeName != "" {
+		i := slices.IndexFunc(h.scopes(), func(s netboot.Scope) bool { return s.Name == scopeName })
+		if i < 0 {
+			return "", "", scope, fmt.Errorf("%w %q", errUnknownScope, scopeName)
+		}
+		scope = h.scopes()[i]
+	}
+
+	var clientIP, warning string
+	if lease, err := db.GetLeaseByMAC(mac, h.Database); err == nil {
+		clientIP = lease.IP
+	}
+
+	script, err := db.GetScriptByMAC(db.BootRequest{
+		MAC:         mac,
+		Scope:       scope.Name,
+		DefaultTask: scope.DefaultTask,
+		Server:      server,
+		ClientIP:    clientIP,
+		Vars:        h.ScriptVars,
+		Preview:     true,
+		OnMenuFallback: func(err error) {
+			warning = "The boot menu failed, so the built-in one is shown: " + err.Error()
+		},
+	}, h.Database)
+	return script, warning, scope, err
+}
+
+// PreviewBootScript replies with the boot script a MAC address would get,
+// without logging a request or using up its task.
+func (h *HttpServer) PreviewBootScript(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	macRegex := regexp.MustCompile(`^([0-9A-Fa-f]{2}:){5}[0-9A-Fa-f]{2}$`)
+	mac := ps.ByName("mac")
+	if !macRegex.MatchString(mac) {
+		http.Error(w, "Invalid MAC Address", http.StatusBadRequest)
+		return
+	}
+
+	script, warning, _, err := h.previewScript(mac, r.URL.Query().Get("scope"), r.Host)
+	if errors.Is(err, db.ErrInvalidScript) {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	} else if errors.Is(err, errUnknownScope) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if warning != "" {
+		w.Header().Set("X-Preview-Warning", strings.ReplaceAll(warning, "\n", " "))
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprint(w, script)
+}
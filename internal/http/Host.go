@@ -10,6 +10,7 @@ import (
 	"strings"
 
 	"github.com/julienschmidt/httprouter"
+	"gorm.io/gorm"
 )
 
 var postRegisterScript = `#!ipxe
@@ -30,7 +31,7 @@ func (h *HttpServer) NewHostiPXE(w http.ResponseWriter, r *http.Request, ps http
 
 	hostname := ps.ByName("hostname")
 
-	err := db.CreateHost(mac, hostname, 0, false, h.Database)
+	_, err := db.CreateHost(mac, hostname, 0, false, h.Database)
 	if err != nil {
 		script := strings.ReplaceAll(postRegisterScript, "#err ", "")
 		fmt.Fprint(w, script)
@@ -53,6 +54,8 @@ func (h *HttpServer) NewHost(w http.ResponseWriter, r *http.Request, ps httprout
 	taskID := r.FormValue("taskID")
 	redirect := r.FormValue("redirect") == "true"
 	taskPerm := r.FormValue("taskPerm") == "on"
+	reservedIP := strings.TrimSpace(r.FormValue("reservedIP"))
+	dhcpHostname := strings.TrimSpace(r.FormValue("dhcpHostname"))
 
 	var taskIDPtr int
 	if taskID != "" {
@@ -64,10 +67,27 @@ func (h *HttpServer) NewHost(w http.ResponseWriter, r *http.Request, ps httprout
 		taskIDPtr = idInt
 	}
 
-	if err := db.CreateHost(mac, name, taskIDPtr, taskPerm, h.Database); err != nil {
+	labels, err := db.ParseLabels(r.FormValue("hostLabels"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = h.Database.Transaction(func(tx *gorm.DB) error {
+		host, err := db.CreateHost(mac, name, taskIDPtr, taskPerm, tx)
+		if err != nil {
+			return err
+		}
+		if err := db.SetHostLabels(labels, host.ID, tx); err != nil {
+			return err
+		}
+		return db.SetHostReservation(reservedIP, dhcpHostname, h.subnets(), host.ID, tx)
+	})
+	if err != nil {
 		http.Error(w, "Update failed: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	h.hostsChanged()
 
 	if redirect {
 		http.Redirect(w, r, "/hosts", http.StatusSeeOther)
@@ -84,6 +104,8 @@ func (h *HttpServer) EditHost(w http.ResponseWriter, r *http.Request, ps httprou
 	taskID := r.FormValue("taskID")
 	redirect := r.FormValue("redirect") == "true"
 	taskPerm := r.FormValue("taskPerm") == "on"
+	reservedIP := strings.TrimSpace(r.FormValue("reservedIP"))
+	dhcpHostname := strings.TrimSpace(r.FormValue("dhcpHostname"))
 
 	var idPtr uint
 	if id != "" {
@@ -105,10 +127,48 @@ func (h *HttpServer) EditHost(w http.ResponseWriter, r *http.Request, ps httprou
 		taskIDPtr = &idInt
 	}
 
-	if err := db.EditHost(name, mac, taskIDPtr, taskPerm, idPtr, h.Database); err != nil {
+	// An empty pinnedRevision boots the latest revision of the task.
+	var pinnedRevision *int
+	if revision := r.FormValue("pinnedRevision"); revision != "" {
+		number, err := strconv.Atoi(revision)
+		if err != nil {
+			http.Error(w, "Invalid pinnedRevision", http.StatusBadRequest)
+			return
+		}
+		pinnedRevision = &number
+	}
+
+	labels, err := db.ParseLabels(r.FormValue("hostLabels"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = h.Database.Transaction(func(tx *gorm.DB) error {
+		if err := db.EditHost(name, mac, taskIDPtr, taskPerm, idPtr, tx); err != nil {
+			return err
+		}
+		if r.Form.Has("pinnedRevision") {
+			if err := db.SetHostPinnedRevision(pinnedRevision, idPtr, tx); err != nil {
+				return err
+			}
+		}
+		if r.Form.Has("hostLabels") {
+			if err := db.SetHostLabels(labels, idPtr, tx); err != nil {
+				return err
+			}
+		}
+		// Scripts posting only the original fields keep the reservation.
+		if !r.Form.Has("reservedIP") && !r.Form.Has("dhcpHostname") {
+			return nil
+		}
+		return db.SetHostReservation(reservedIP, dhcpHostname, h.subnets(), idPtr, tx)
+	})
+	if err != nil {
 		http.Error(w, "Update failed: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	h.hostsChanged()
 
 	if redirect {
 		http.Redirect(w, r, "/hosts", http.StatusSeeOther)
@@ -127,6 +187,7 @@ func (h *HttpServer) DeleteHost(w http.ResponseWriter, r *http.Request, ps httpr
 		http.Error(w, "Update failed: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	h.hostsChanged()
 
 	if redirect {
 		http.Redirect(w, r, "/hosts", http.StatusSeeOther)
@@ -0,0 +1,155 @@
+package httpserver
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"pxehub/internal/db"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+)
+
+type hostImportRowJSON struct {
+	Line      int               `json:"line"`
+	Name      string            `json:"name"`
+	Mac       string            `json:"mac"`
+	Task      string            `json:"task"`
+	Permanent bool              `json:"permanent"`
+	WifiKey   string            `json:"wifi_key"`
+	Labels    map[string]string `json:"labels"`
+	Action    string            `json:"action"`
+	Errors    []string          `json:"errors"`
+}
+
+type hostImportJSON struct {
+	DryRun  bool                `json:"dry_run"`
+	Valid   bool                `json:"valid"`
+	Created int                 `json:"created"`
+	Updated int                 `json:"updated"`
+	Rows    []hostImportRowJSON `json:"rows"`
+}
+
+func toHostImportJSON(result *db.HostImport) hostImportJSON {
+	rows := make([]hostImportRowJSON, 0, len(result.Rows))
+	for _, row := range result.Rows {
+		errs := row.Errors
+		if errs == nil {
+			errs = []string{}
+		}
+		rows = append(rows, hostImportRowJSON{
+			Line:      row.Line,
+			Name:      row.Name,
+			Mac:       row.Mac,
+			Task:      row.Task,
+			Permanent: row.Permanent,
+			WifiKey:   row.WifiKey,
+			Labels:    row.Labels,
+			Action:    row.Action,
+			Errors:    errs,
+		})
+	}
+
+	return hostImportJSON{
+		DryRun:  result.DryRun,
+		Valid:   result.Valid(),
+		Created: result.Created,
+		Updated: result.Updated,
+		Rows:    rows,
+	}
+}
+
+// renderHostImport shows the host import page, with the result of an
+// import or preview if there is one. hostsCSV is the uploaded CSV, which a
+// valid preview posts again to import it.
+func (h *HttpServer) renderHostImport(w http.ResponseWriter, r *http.Request, result *db.HostImport, hostsCSV, importErr string) {
+	w.Header().Set("Content-Type", "text/html")
+	caser := cases.Title(language.English)
+	user := currentUser(r)
+
+	tmpl, err := parseTemplates("base.html", "hosts.html")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]any{
+		"Title":       caser.String("import hosts"),
+		"Name":        user.Name,
+		"Path":        "/hosts/import",
+		"CurrentUser": user,
+		"Import":      result,
+		"HostsCSV":    hostsCSV,
+		"ImportError": importErr,
+	}
+
+	if err := tmpl.ExecuteTemplate(w, "base", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// readHostsCSVForm returns the CSV uploaded as the hostsFile file, or else
+// sent as the hostsCSV field.
+func readHostsCSVForm(r *http.Request) (string, error) {
+	file, _, err := r.FormFile("hostsFile")
+	if errors.Is(err, http.ErrMissingFile) || errors.Is(err, http.ErrNotMultipart) {
+		return r.FormValue("hostsCSV"), nil
+	} else if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	return string(data), err
+}
+
+func (h *HttpServer) ImportHosts(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	dryRun := r.FormValue("dryRun") == "true"
+	redirect := r.FormValue("redirect") == "true"
+
+	hostsCSV, err := readHostsCSVForm(r)
+	if err == nil && strings.TrimSpace(hostsCSV) == "" {
+		err = errors.New("no csv was uploaded")
+	}
+
+	var result *db.HostImport
+	if err == nil {
+		allowWifiKeys := currentUser(r).HasRole(db.RoleAdmin)
+		result, err = db.ImportHostsCSV(strings.NewReader(hostsCSV), dryRun, allowWifiKeys, h.Database)
+	}
+	if err != nil {
+		if redirect {
+			h.renderHostImport(w, r, nil, "", err.Error())
+		} else {
+			http.Error(w, "Import failed: "+err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	if !dryRun && result.Valid() {
+		h.hostsChanged()
+	}
+
+	if redirect {
+		h.renderHostImport(w, r, result, hostsCSV, "")
+		return
+	}
+
+	status := http.StatusOK
+	if !result.Valid() {
+		status = http.StatusUnprocessableEntity
+	}
+	writeJSON(w, status, toHostImportJSON(result))
+}
+
+func (h *HttpServer) ExportHosts(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="hosts.csv"`)
+
+	// Only admins, or tokens created by admins, may see wifi keys.
+	if err := db.ExportHostsCSV(w, currentUser(r).HasRole(db.RoleAdmin), h.Database); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
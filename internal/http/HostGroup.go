@@ -0,0 +1,134 @@
+package httpserver
+
+import (
+	"fmt"
+	"net/http"
+	"pxehub/internal/db"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// parseHostIDs returns the hostIDs form values, one per selected member,
+// or nil if the field was not sent. Empty values are skipped, so a form can
+// send an empty list.
+func parseHostIDs(r *http.Request) ([]uint, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	if !r.Form.Has("hostIDs") {
+		return nil, nil
+	}
+
+	ids := []uint{}
+	for _, value := range r.Form["hostIDs"] {
+		if value == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(value, 10, 0)
+		if err != nil {
+			return nil, fmt.Errorf("invalid host ID %q", value)
+		}
+		ids = append(ids, uint(id))
+	}
+	return ids, nil
+}
+
+func (h *HttpServer) NewHostGroup(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	name := r.FormValue("groupName")
+	taskID := r.FormValue("taskID")
+	redirect := r.FormValue("redirect") == "true"
+	taskPerm := r.FormValue("taskPerm") == "on"
+
+	if name == "" {
+		http.Error(w, "Missing fields", http.StatusBadRequest)
+		return
+	}
+
+	var taskIDInt int
+	if taskID != "" {
+		idInt, err := strconv.Atoi(taskID)
+		if err != nil {
+			http.Error(w, "Invalid taskID", http.StatusBadRequest)
+			return
+		}
+		taskIDInt = idInt
+	}
+
+	hostIDs, err := parseHostIDs(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := db.CreateHostGroup(name, taskIDInt, taskPerm, hostIDs, h.Database); err != nil {
+		http.Error(w, "Create failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if redirect {
+		http.Redirect(w, r, "/groups", http.StatusSeeOther)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"ok"}`))
+	}
+}
+
+func (h *HttpServer) EditHostGroup(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.ParseUint(ps.ByName("id"), 10, 0)
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+
+	name := r.FormValue("groupName")
+	taskID := r.FormValue("taskID")
+	redirect := r.FormValue("redirect") == "true"
+	taskPerm := r.FormValue("taskPerm") == "on"
+
+	var taskIDPtr *int
+	if taskID != "" {
+		idInt, err := strconv.Atoi(taskID)
+		if err != nil {
+			http.Error(w, "Invalid taskID", http.StatusBadRequest)
+			return
+		}
+		taskIDPtr = &idInt
+	}
+
+	hostIDs, err := parseHostIDs(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := db.EditHostGroup(name, taskIDPtr, taskPerm, hostIDs, uint(id), h.Database); err != nil {
+		http.Error(w, "Update failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if redirect {
+		http.Redirect(w, r, fmt.Sprintf("/groups/edit/%d", id), http.StatusSeeOther)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"ok"}`))
+	}
+}
+
+func (h *HttpServer) DeleteHostGroup(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+
+	redirect := r.FormValue("redirect") == "true"
+
+	if err := db.DeleteHostGroup(id, h.Database); err != nil {
+		http.Error(w, "Update failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if redirect {
+		http.Redirect(w, r, "/groups", http.StatusSeeOther)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"ok"}`))
+	}
+}
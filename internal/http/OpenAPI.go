@@ -0,0 +1,97 @@
+package httpserver
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+//go:embed openapi.json
+var openAPISpec []byte
+
+func (h *HttpServer) OpenAPI(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
+
+type route struct {
+	Method string
+	Path   string
+}
+
+// routeRecorder is an httprouter.Router that remembers every route
+// registered on it, so they can be checked against the OpenAPI document.
+type routeRecorder struct {
+	*httprouter.Router
+	routes []route
+}
+
+func newRouteRecorder() *routeRecorder {
+	return &routeRecorder{Router: httprouter.New()}
+}
+
+func (rr *routeRecorder) Handle(method, path string, handle httprouter.Handle) {
+	rr.routes = append(rr.routes, route{Method: method, Path: path})
+	rr.Router.Handle(method, path, handle)
+}
+
+func (rr *routeRecorder) GET(path string, handle httprouter.Handle) {
+	rr.Handle(http.MethodGet, path, handle)
+}
+
+func (rr *routeRecorder) POST(path string, handle httprouter.Handle) {
+	rr.Handle(http.MethodPost, path, handle)
+}
+
+func (rr *routeRecorder) PUT(path string, handle httprouter.Handle) {
+	rr.Handle(http.MethodPut, path, handle)
+}
+
+func (rr *routeRecorder) DELETE(path string, handle httprouter.Handle) {
+	rr.Handle(http.MethodDelete, path, handle)
+}
+
+func (rr *routeRecorder) ServeFiles(path string, root http.FileSystem) {
+	rr.routes = append(rr.routes, route{Method: http.MethodGet, Path: path})
+	rr.Router.ServeFiles(path, root)
+}
+
+// openAPIPath converts an httprouter path such as /hosts/:id to the OpenAPI
+// form /hosts/{id}.
+func openAPIPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if strings.HasPrefix(s, ":") || strings.HasPrefix(s, "*") {
+			segments[i] = "{" + s[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// checkOpenAPICoverage returns an error listing any route that has no
+// operation in the OpenAPI document, so the two cannot drift apart.
+func checkOpenAPICoverage(routes []route) error {
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		return fmt.Errorf("invalid openapi.json: %w", err)
+	}
+
+	var missing []string
+	for _, rt := range routes {
+		if _, ok := spec.Paths[openAPIPath(rt.Path)][strings.ToLower(rt.Method)]; !ok {
+			missing = append(missing, rt.Method+" "+rt.Path)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("routes missing from openapi.json: %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
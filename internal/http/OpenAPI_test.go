@@ -0,0 +1,10 @@
+package httpserver
+
+import "testing"
+
+func TestOpenAPICoverage(t *testing.T) {
+	h := &HttpServer{ExtrasDir: t.TempDir()}
+	if err := checkOpenAPICoverage(h.newRouter().routes); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -4,11 +4,13 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"pxehub/internal/db"
+	"pxehub/internal/netboot"
 	"strings"
 	"time"
 
-	"github.com/julienschmidt/httprouter"
 	"gorm.io/gorm"
 )
 
@@ -17,6 +19,75 @@ type HttpServer struct {
 	Server    *http.Server
 	Database  *gorm.DB
 	ExtrasDir string
+	// ScriptVars are the custom variables of task scripts.
+	ScriptVars map[string]string
+
+	// Scopes, if set, returns the DHCP scopes. Every reserved IP must be in
+	// the subnet of one of them.
+	Scopes func() []netboot.Scope
+	// OnHostsChanged is called after a host is created, edited or deleted.
+	OnHostsChanged func()
+	// ReloadDHCP reloads the DHCP and TFTP server configuration.
+	ReloadDHCP func() error
+	// DHCPStatus reports the state of the DHCP and TFTP server.
+	DHCPStatus func() netboot.Status
+}
+
+func (h *HttpServer) scopes() []netboot.Scope {
+	if h.Scopes == nil {
+		return nil
+	}
+	return h.Scopes()
+}
+
+// subnets returns the subnets of the DHCP scopes.
+func (h *HttpServer) subnets() []*net.IPNet {
+	var subnets []*net.IPNet
+	for _, scope := range h.scopes() {
+		if subnet := scope.Subnet(); subnet != nil {
+			subnets = append(subnets, subnet)
+		}
+	}
+	return subnets
+}
+
+// scopeFor returns the scope a client booted from: the one whose subnet
+// contains the request's address, or else the scope of the client's last
+// lease. It returns the zero Scope if neither is known.
+func (h *HttpServer) scopeFor(r *http.Request, mac string) netboot.Scope {
+	scopes := h.scopes()
+
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if ip := net.ParseIP(host); ip != nil {
+			for _, scope := range scopes {
+				if subnet := scope.Subnet(); subnet != nil && subnet.Contains(ip) {
+					return scope
+				}
+			}
+		}
+	}
+
+	return h.scopeForMAC(mac)
+}
+
+// scopeForMAC returns the scope of the client's last lease, or the zero
+// Scope if it is not known.
+func (h *HttpServer) scopeForMAC(mac string) netboot.Scope {
+	if lease, err := db.GetLeaseByMAC(mac, h.Database); err == nil {
+		for _, scope := range h.scopes() {
+			if scope.Name == lease.Scope {
+				return scope
+			}
+		}
+	}
+
+	return netboot.Scope{}
+}
+
+func (h *HttpServer) hostsChanged() {
+	if h.OnHostsChanged != nil {
+		h.OnHostsChanged()
+	}
 }
 
 func loggingMiddleware(next http.Handler) http.Handler {
@@ -36,50 +107,126 @@ func loggingMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-func (h *HttpServer) Start() error {
-
-	log.Printf("Starting http listening on %s", h.Address)
-
-	router := httprouter.New()
+// newRouter registers every route of the server.
+func (h *HttpServer) newRouter() *routeRecorder {
+	router := newRouteRecorder()
 
 	// iPXE Client
 	router.GET("/api/boot/:mac", h.BootScript)
 	router.GET("/api/new/host/:mac/:hostname", h.NewHostiPXE)
 	router.GET("/api/get/wifikey/:mac", h.GetWifiKey)
 
+	// Authentication
+	router.GET("/login", h.LoginPage)
+	router.POST("/api/login", h.Login)
+	router.POST("/api/setup", h.Setup)
+	router.POST("/api/logout", h.Logout)
+
+	// Roles allowed per route group. Operators may manage hosts and assign
+	// them tasks, but only admins may change task scripts, wifi keys or users.
+	// Object routes also accept API tokens scoped to that object.
+	viewers := []string{db.RoleAdmin, db.RoleOperator, db.RoleViewer}
+	hostEditors := []string{db.RoleAdmin, db.RoleOperator}
+	admins := []string{db.RoleAdmin}
+
 	// New Object
-	router.POST("/api/new/host", h.NewHost)
-	router.POST("/api/new/task", h.NewTask)
-	router.POST("/api/new/wifikey", h.NewWifiKey)
+	router.POST("/api/new/host", h.requireAPI(h.NewHost, db.ScopeHosts, hostEditors...))
+	router.POST("/api/new/group", h.requireAPI(h.NewHostGroup, db.ScopeHosts, hostEditors...))
+	router.POST("/api/new/task", h.requireAPI(h.NewTask, db.ScopeTasks, admins...))
+	router.POST("/api/new/wifikey", h.requireAPI(h.NewWifiKey, db.ScopeWifiKeys, admins...))
+	router.POST("/api/new/user", h.requireRole(h.NewUser, admins...))
 
 	// Update Object
-	router.POST("/api/edit/host/:id", h.EditHost)
-	router.POST("/api/edit/task/:id", h.EditTask)
-	router.POST("/api/edit/wifikey/:id", h.EditWifiKey)
+	router.POST("/api/edit/host/:id", h.requireAPI(h.EditHost, db.ScopeHosts, hostEditors...))
+	router.POST("/api/edit/group/:id", h.requireAPI(h.EditHostGroup, db.ScopeHosts, hostEditors...))
+	router.POST("/api/edit/task/:id", h.requireAPI(h.EditTask, db.ScopeTasks, admins...))
+	router.POST("/api/rollback/task/:id", h.requireAPI(h.RollbackTask, db.ScopeTasks, admins...))
+	router.POST("/api/edit/menu/:name", h.requireAPI(h.EditBootMenu, db.ScopeTasks, admins...))
+	router.POST("/api/reset/menu/:name", h.requireAPI(h.ResetBootMenu, db.ScopeTasks, admins...))
+	router.POST("/api/edit/wifikey/:id", h.requireAPI(h.EditWifiKey, db.ScopeWifiKeys, admins...))
+	router.POST("/api/edit/user/:id", h.requireRole(h.EditUser, admins...))
 
 	// Delete Object
-	router.POST("/api/delete/host/:id", h.DeleteHost)
-	router.POST("/api/delete/task/:id", h.DeleteTask)
-	router.POST("/api/delete/wifikey/:id", h.DeleteWifiKey)
+	router.POST("/api/delete/host/:id", h.requireAPI(h.DeleteHost, db.ScopeHosts, hostEditors...))
+	router.POST("/api/delete/group/:id", h.requireAPI(h.DeleteHostGroup, db.ScopeHosts, hostEditors...))
+	router.POST("/api/delete/task/:id", h.requireAPI(h.DeleteTask, db.ScopeTasks, admins...))
+	router.POST("/api/delete/wifikey/:id", h.requireAPI(h.DeleteWifiKey, db.ScopeWifiKeys, admins...))
+	router.POST("/api/delete/user/:id", h.requireRole(h.DeleteUser, admins...))
+
+	// Bulk Hosts
+	router.POST("/api/import/hosts", h.requireAPI(h.ImportHosts, db.ScopeHosts, hostEditors...))
+	router.GET("/api/export/hosts", h.requireAPI(h.ExportHosts, db.ScopeHosts, viewers...))
+	router.GET("/api/preview/boot/:mac", h.requireAPI(h.PreviewBootScript, db.ScopeHosts, viewers...))
+
+	// DHCP
+	router.GET("/api/v1/dhcp/status", h.requireAPI(h.GetDHCPStatusV1, db.ScopeDHCP, viewers...))
+	router.POST("/api/v1/dhcp/reload", h.requireAPI(h.ReloadDHCPV1, db.ScopeDHCP, admins...))
+
+	// REST API
+	router.GET("/api/v1/hosts", h.requireAPI(h.ListHostsV1, db.ScopeHosts, viewers...))
+	router.POST("/api/v1/hosts", h.requireAPI(h.CreateHostV1, db.ScopeHosts, hostEditors...))
+	router.GET("/api/v1/hosts/:id", h.requireAPI(h.GetHostV1, db.ScopeHosts, viewers...))
+	router.PUT("/api/v1/hosts/:id", h.requireAPI(h.UpdateHostV1, db.ScopeHosts, hostEditors...))
+	router.DELETE("/api/v1/hosts/:id", h.requireAPI(h.DeleteHostV1, db.ScopeHosts, hostEditors...))
+	router.GET("/api/v1/hosts/:id/preview", h.requireAPI(h.PreviewHostV1, db.ScopeHosts, viewers...))
+	router.GET("/api/v1/tasks", h.requireAPI(h.ListTasksV1, db.ScopeTasks, viewers...))
+	router.POST("/api/v1/tasks", h.requireAPI(h.CreateTaskV1, db.ScopeTasks, admins...))
+	router.GET("/api/v1/tasks/:id", h.requireAPI(h.GetTaskV1, db.ScopeTasks, viewers...))
+	router.PUT("/api/v1/tasks/:id", h.requireAPI(h.UpdateTaskV1, db.ScopeTasks, admins...))
+	router.DELETE("/api/v1/tasks/:id", h.requireAPI(h.DeleteTaskV1, db.ScopeTasks, admins...))
+	router.GET("/api/v1/tasks/:id/revisions", h.requireAPI(h.ListTaskRevisionsV1, db.ScopeTasks, viewers...))
+	router.GET("/api/v1/tasks/:id/revisions/:number", h.requireAPI(h.GetTaskRevisionV1, db.ScopeTasks, viewers...))
+	router.POST("/api/v1/tasks/:id/rollback", h.requireAPI(h.RollbackTaskV1, db.ScopeTasks, admins...))
+	router.GET("/api/v1/wifikeys", h.requireAPI(h.ListWifiKeysV1, db.ScopeWifiKeys, admins...))
+	router.POST("/api/v1/wifikeys", h.requireAPI(h.CreateWifiKeyV1, db.ScopeWifiKeys, admins...))
+	router.GET("/api/v1/wifikeys/:id", h.requireAPI(h.GetWifiKeyV1, db.ScopeWifiKeys, admins...))
+	router.PUT("/api/v1/wifikeys/:id", h.requireAPI(h.UpdateWifiKeyV1, db.ScopeWifiKeys, admins...))
+	router.DELETE("/api/v1/wifikeys/:id", h.requireAPI(h.DeleteWifiKeyV1, db.ScopeWifiKeys, admins...))
+
+	// API Tokens
+	router.POST("/api/new/token", h.requireRole(h.NewAPIToken, admins...))
+	router.POST("/api/delete/token/:id", h.requireRole(h.DeleteAPIToken, admins...))
+
+	// Documentation
+	router.GET("/api/openapi.json", h.OpenAPI)
 
 	// UI
-	router.GET("/", h.UI)
-	router.GET("/hosts", h.UI)
-	router.GET("/hosts/new", h.UI)
-	router.GET("/hosts/edit/:id", h.UI)
-	router.GET("/tasks", h.UI)
-	router.GET("/tasks/new", h.UI)
-	router.GET("/tasks/edit/:id", h.UI)
-	router.GET("/wifikeys", h.UI)
-	router.GET("/wifikeys/new", h.UI)
-	router.GET("/wifikeys/edit/:id", h.UI)
+	router.GET("/", h.requireRole(h.UI, viewers...))
+	router.GET("/hosts", h.requireRole(h.UI, viewers...))
+	router.GET("/hosts/new", h.requireRole(h.UI, hostEditors...))
+	router.GET("/hosts/import", h.requireRole(h.UI, hostEditors...))
+	router.GET("/hosts/edit/:id", h.requireRole(h.UI, viewers...))
+	router.GET("/groups", h.requireRole(h.UI, viewers...))
+	router.GET("/groups/new", h.requireRole(h.UI, hostEditors...))
+	router.GET("/groups/edit/:id", h.requireRole(h.UI, viewers...))
+	router.GET("/tasks", h.requireRole(h.UI, viewers...))
+	router.GET("/tasks/new", h.requireRole(h.UI, admins...))
+	router.GET("/tasks/edit/:id", h.requireRole(h.UI, viewers...))
+	router.GET("/menus", h.requireRole(h.UI, viewers...))
+	router.GET("/wifikeys", h.requireRole(h.UI, viewers...))
+	router.GET("/leases", h.requireRole(h.UI, viewers...))
+	router.GET("/wifikeys/new", h.requireRole(h.UI, admins...))
+	router.GET("/wifikeys/edit/:id", h.requireRole(h.UI, admins...))
+	router.GET("/users", h.requireRole(h.UI, admins...))
+	router.GET("/users/new", h.requireRole(h.UI, admins...))
+	router.GET("/users/edit/:id", h.requireRole(h.UI, admins...))
+	router.GET("/tokens", h.requireRole(h.UI, admins...))
+	router.GET("/tokens/new", h.requireRole(h.UI, admins...))
+	router.GET("/docs", h.requireRole(h.UI, viewers...))
 
 	// User Extras
 	router.ServeFiles("/extras/*filepath", http.Dir(h.ExtrasDir))
 
+	return router
+}
+
+func (h *HttpServer) Start() error {
+
+	log.Printf("Starting http listening on %s", h.Address)
+
 	h.Server = &http.Server{
 		Addr:    h.Address,
-		Handler: loggingMiddleware(router),
+		Handler: loggingMiddleware(h.newRouter()),
 	}
 
 	errChan := make(chan error, 1)
@@ -1,10 +1,15 @@
 package httpserver
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"pxehub/internal/db"
+	"strconv"
 
 	"github.com/julienschmidt/httprouter"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
 )
 
 func (h *HttpServer) NewTask(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
@@ -17,7 +22,21 @@ func (h *HttpServer) NewTask(w http.ResponseWriter, r *http.Request, ps httprout
 		return
 	}
 
-	db.CreateTask(name, script, h.Database)
+	task := &db.Task{Name: name, Script: script}
+	if !h.checkTaskScript(w, r, task, redirect) {
+		return
+	}
+
+	if _, err := db.CreateTask(name, script, author(r), r.FormValue("taskComment"), h.Database); errors.Is(err, db.ErrInvalidScript) && redirect {
+		h.renderTaskForm(w, r, task, err.Error())
+		return
+	} else if errors.Is(err, db.ErrInvalidScript) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "Create failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	if redirect {
 		http.Redirect(w, r, "/tasks", http.StatusSeeOther)
@@ -34,7 +53,19 @@ func (h *HttpServer) EditTask(w http.ResponseWriter, r *http.Request, ps httprou
 	script := r.FormValue("taskScript")
 	redirect := r.FormValue("redirect") == "true"
 
-	if err := db.EditTask(name, script, id, h.Database); err != nil {
+	idInt, _ := strconv.Atoi(id)
+	task := &db.Task{ID: idInt, Name: name, Script: script}
+	if !h.checkTaskScript(w, r, task, redirect) {
+		return
+	}
+
+	if err := db.EditTask(name, script, id, author(r), r.FormValue("taskComment"), h.Database); errors.Is(err, db.ErrInvalidScript) && redirect {
+		h.renderTaskForm(w, r, task, err.Error())
+		return
+	} else if errors.Is(err, db.ErrInvalidScript) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
 		http.Error(w, "Update failed: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -64,3 +95,137 @@ func (h *HttpServer) DeleteTask(w http.ResponseWriter, r *http.Request, ps httpr
 		w.Write([]byte(`{"status":"ok"}`))
 	}
 }
+
+// RollbackTask makes an earlier revision's script the task's script again.
+func (h *HttpServer) RollbackTask(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		return
+	}
+	revision, err := strconv.Atoi(r.FormValue("revision"))
+	if err != nil {
+		http.Error(w, "Invalid revision", http.StatusBadRequest)
+		return
+	}
+	redirect := r.FormValue("redirect") == "true"
+
+	if err := db.RollbackTask(id, revision, author(r), r.FormValue("taskComment"), h.Database); errors.Is(err, db.ErrInvalidScript) && redirect {
+		h.renderRollbackError(w, r, id, revision, err.Error())
+		return
+	} else if errors.Is(err, db.ErrNoSuchRevision) || errors.Is(err, db.ErrInvalidScript) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "Rollback failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if redirect {
+		http.Redirect(w, r, fmt.Sprintf("/tasks/edit/%d?history=1", id), http.StatusSeeOther)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"ok"}`))
+	}
+}
+
+// renderRollbackError shows the task form with the script of the revision
+// that could not be rolled back to, and why, so it can be fixed and saved.
+func (h *HttpServer) renderRollbackError(w http.ResponseWriter, r *http.Request, id, number int, taskErr string) {
+	task, err := db.GetTaskByID(strconv.Itoa(id), h.Database)
+	if err != nil {
+		http.Error(w, "Rollback failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	revision, err := db.GetTaskRevision(id, number, h.Database)
+	if err != nil {
+		http.Error(w, "Rollback failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	task.Script = revision.Script
+	h.renderTaskForm(w, r, task, taskErr)
+}
+
+// checkTaskScript stops a task being saved when its script has iPXE errors,
+// or warnings the user has not chosen to save anyway with force=true, and
+// shows them. It reports whether saving can go ahead.
+func (h *HttpServer) checkTaskScript(w http.ResponseWriter, r *http.Request, task *db.Task, redirect bool) bool {
+	return checkScript(w, r, task.Script, redirect, func() {
+		h.renderTaskForm(w, r, task, "")
+	})
+}
+
+// checkScript is checkTaskScript for any script. With redirect set, the
+// problems are shown by rerender.
+func checkScript(w http.ResponseWriter, r *http.Request, script string, redirect bool, rerender func()) bool {
+	issues := db.CheckIPXEScript(script)
+	hasErrors := db.HasScriptErrors(issues)
+	if len(issues) == 0 || (!hasErrors && r.FormValue("force") == "true") {
+		return true
+	}
+
+	if redirect {
+		rerender()
+		return false
+	}
+
+	msg := "Script has warnings, send force=true to save anyway:"
+	if hasErrors {
+		msg = "Script has errors:"
+	}
+	for _, issue := range issues {
+		kind := "error"
+		if issue.Warning {
+			kind = "warning"
+		}
+		msg += fmt.Sprintf("\n%s, %s", kind, issue)
+	}
+	http.Error(w, msg, http.StatusBadRequest)
+	return false
+}
+
+// renderTaskForm shows the new or edit task form again, keeping what was
+// posted, with the error that stopped the task being saved and any iPXE
+// errors or warnings in its script.
+func (h *HttpServer) renderTaskForm(w http.ResponseWriter, r *http.Request, task *db.Task, taskErr string) {
+	user := currentUser(r)
+	caser := cases.Title(language.English)
+
+	page, title, path := "tasks.html", "tasks", "/tasks/new"
+	if task.ID != 0 {
+		page, title, path = "tasks_edit.html", "edit task", fmt.Sprintf("/tasks/edit/%d", task.ID)
+	}
+
+	issues := db.CheckIPXEScript(task.Script)
+
+	tmpl, err := parseTemplates("base.html", page, "script_help.html")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]any{
+		"Title":        caser.String(title),
+		"Name":         user.Name,
+		"Path":         path,
+		"CurrentUser":  user,
+		"Task":         task,
+		"TaskError":    taskErr,
+		"ScriptIssues": issues,
+		"ScriptErrors": db.HasScriptErrors(issues),
+		"Force":        r.FormValue("force") == "true",
+		"TaskComment":  r.FormValue("taskComment"),
+	}
+	if task.ID != 0 {
+		if err := h.taskHistoryData(r, task.ID, data); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusBadRequest)
+	if err := tmpl.ExecuteTemplate(w, "base", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -3,9 +3,12 @@ package httpserver
 import (
 	"html/template"
 	"net/http"
+	"net/url"
 	"os"
 	"pxehub/internal/db"
+	"pxehub/internal/netboot"
 	"pxehub/ui"
+	"strconv"
 	"strings"
 	"time"
 
@@ -17,13 +20,138 @@ import (
 func parseTemplates(files ...string) (*template.Template, error) {
 	return template.New(files[0]).Funcs(template.FuncMap{
 		"contains": strings.Contains,
+		"uptime": func(seconds int64) string {
+			return (time.Duration(seconds) * time.Second).String()
+		},
 	}).ParseFS(ui.Content, files...)
 }
 
+// scopeSummary is a DHCP scope with its activity this month, shown on the
+// dashboard.
+type scopeSummary struct {
+	netboot.Scope
+	db.ScopeActivity
+}
+
+const hostsPerPage = 100
+
+// taskHistoryData adds a task's revisions to data, and the diff between the
+// revisions in the from and to query parameters, or else between the last
+// two.
+func (h *HttpServer) taskHistoryData(r *http.Request, taskID int, data map[string]any) error {
+	revisions, err := db.GetTaskRevisions(taskID, h.Database)
+	if err != nil {
+		return err
+	}
+	data["Revisions"] = revisions
+
+	query := r.URL.Query()
+	data["ShowHistory"] = query.Has("history") || query.Has("from")
+	if len(revisions) == 0 {
+		data["DiffFrom"], data["DiffTo"], data["LatestRevision"] = 0, 0, 0
+		return nil
+	}
+
+	latest := revisions[0].Number
+	from, to := latest, latest
+	if len(revisions) > 1 {
+		from = revisions[1].Number
+	}
+	if query.Has("from") || query.Has("to") {
+		from, err = strconv.Atoi(query.Get("from"))
+		if err == nil {
+			to, err = strconv.Atoi(query.Get("to"))
+		}
+		if err != nil {
+			data["DiffError"] = "Invalid revision number"
+			from, to = latest, latest
+		}
+	}
+	data["DiffFrom"], data["DiffTo"], data["LatestRevision"] = from, to, latest
+
+	if data["DiffError"] != nil {
+		return nil
+	}
+	oldRevision, err := db.GetTaskRevision(taskID, from, h.Database)
+	if err != nil {
+		data["DiffError"] = err.Error()
+		return nil
+	}
+	newRevision, err := db.GetTaskRevision(taskID, to, h.Database)
+	if err != nil {
+		data["DiffError"] = err.Error()
+		return nil
+	}
+	data["Diff"] = db.DiffScripts(oldRevision.Script, newRevision.Script)
+	return nil
+}
+
+// hostListData adds a page of the host list to data, filtered by the q
+// (name or MAC address) and labels query parameters.
+func (h *HttpServer) hostListData(r *http.Request, data map[string]any) error {
+	search := strings.TrimSpace(r.URL.Query().Get("q"))
+	labelFilter := strings.TrimSpace(r.URL.Query().Get("labels"))
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	data["Search"] = search
+	data["LabelFilter"] = labelFilter
+
+	labels, err := db.ParseLabelFilter(labelFilter)
+	if err != nil {
+		data["FilterError"] = err.Error()
+		return nil
+	}
+
+	filter := db.HostFilter{Search: search, Labels: labels}
+	opts := db.ListOptions{Offset: (page - 1) * hostsPerPage, Limit: hostsPerPage}
+	hosts, total, err := db.ListHosts(filter, opts, h.Database)
+	if err != nil {
+		return err
+	}
+	labelKeys, err := db.GetLabelKeys(h.Database)
+	if err != nil {
+		return err
+	}
+
+	pageURL := func(page int) string {
+		query := url.Values{}
+		if search != "" {
+			query.Set("q", search)
+		}
+		if labelFilter != "" {
+			query.Set("labels", labelFilter)
+		}
+		if page > 1 {
+			query.Set("page", strconv.Itoa(page))
+		}
+		if len(query) == 0 {
+			return "/hosts"
+		}
+		return "/hosts?" + query.Encode()
+	}
+
+	data["Hosts"] = hosts
+	data["Total"] = total
+	data["LabelKeys"] = labelKeys
+	data["First"] = opts.Offset + 1
+	data["Last"] = opts.Offset + len(hosts)
+	if page > 1 {
+		data["PrevURL"] = pageURL(page - 1)
+	}
+	if int64(opts.Offset+len(hosts)) < total {
+		data["NextURL"] = pageURL(page + 1)
+	}
+	return nil
+}
+
 func (h *HttpServer) UI(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	w.Header().Set("Content-Type", "text/html")
 	path := strings.Trim(r.URL.Path, "/")
 	caser := cases.Title(language.English)
+	user := currentUser(r)
 
 	switch path {
 	case "":
@@ -63,11 +191,26 @@ func (h *HttpServer) UI(w http.ResponseWriter, r *http.Request, ps httprouter.Pa
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		year, month, _ := time.Now().Date()
+		activity, err := db.GetScopeActivity(time.Date(year, month, 1, 0, 0, 0, 0, time.Local), h.Database)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		scopes := []scopeSummary{}
+		for _, scope := range h.scopes() {
+			summary := scopeSummary{Scope: scope}
+			if a := activity[scope.Name]; a != nil {
+				summary.ScopeActivity = *a
+			}
+			scopes = append(scopes, summary)
+		}
 
 		data := map[string]any{
 			"Title":                 caser.String("Home"),
-			"Name":                  "User",
+			"Name":                  user.Name,
 			"Path":                  r.URL.Path,
+			"CurrentUser":           user,
 			"RegisteredGraphData":   graphData1,
 			"UnregisteredGraphData": graphData2,
 			"GraphDates":            graphDates,
@@ -76,6 +219,8 @@ func (h *HttpServer) UI(w http.ResponseWriter, r *http.Request, ps httprouter.Pa
 			"TotalHosts":            totalHosts,
 			"ActiveTasks":           activeTasks,
 			"AvailableWifiKeys":     availableWifiKeys,
+			"DHCP":                  toDHCPStatusJSON(h.dhcpStatus()),
+			"Scopes":                scopes,
 		}
 
 		if err := tmpl.ExecuteTemplate(w, "base", data); err != nil {
@@ -94,24 +239,71 @@ func (h *HttpServer) UI(w http.ResponseWriter, r *http.Request, ps httprouter.Pa
 			return
 		}
 
-		hostsHtml, err := db.GetHostsAsHTML(h.Database)
+		tasks, err := db.GetTasks(h.Database)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
+		data := map[string]any{
+			"Title":       caser.String("hosts"),
+			"Name":        user.Name,
+			"Path":        r.URL.Path,
+			"CurrentUser": user,
+			"Tasks":       tasks,
+			"Subnets":     h.subnets(),
+		}
+
+		if path == "hosts" {
+			if err := h.hostListData(r, data); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		if err := tmpl.ExecuteTemplate(w, "base", data); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+
+	case "hosts/import":
+		h.renderHostImport(w, r, nil, "", "")
+
+	case "groups", "groups/new":
+		files := []string{"base.html", "groups.html"}
+		tmpl, err := parseTemplates(files...)
+		if err != nil {
+			if os.IsNotExist(err) {
+				http.NotFound(w, r)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		groups, err := db.GetHostGroups(h.Database)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 		tasks, err := db.GetTasks(h.Database)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		hosts, _, err := db.ListHosts(db.HostFilter{}, db.ListOptions{Limit: -1}, h.Database)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 
 		data := map[string]any{
-			"Title": caser.String("hosts"),
-			"Name":  "User",
-			"Path":  r.URL.Path,
-			"Hosts": template.HTML(hostsHtml),
-			"Tasks": tasks,
+			"Title":       caser.String("groups"),
+			"Name":        user.Name,
+			"Path":        r.URL.Path,
+			"CurrentUser": user,
+			"Groups":      groups,
+			"Tasks":       tasks,
+			"Hosts":       hosts,
 		}
 
 		if err := tmpl.ExecuteTemplate(w, "base", data); err != nil {
@@ -119,7 +311,7 @@ func (h *HttpServer) UI(w http.ResponseWriter, r *http.Request, ps httprouter.Pa
 		}
 
 	case "tasks", "tasks/new":
-		files := []string{"base.html", "tasks.html"}
+		files := []string{"base.html", "tasks.html", "script_help.html"}
 		tmpl, err := parseTemplates(files...)
 		if err != nil {
 			if os.IsNotExist(err) {
@@ -137,10 +329,11 @@ func (h *HttpServer) UI(w http.ResponseWriter, r *http.Request, ps httprouter.Pa
 		}
 
 		data := map[string]any{
-			"Title": caser.String("tasks"),
-			"Name":  "User",
-			"Path":  r.URL.Path,
-			"Tasks": tasksHtml,
+			"Title":       caser.String("tasks"),
+			"Name":        user.Name,
+			"Path":        r.URL.Path,
+			"CurrentUser": user,
+			"Tasks":       tasksHtml,
 		}
 
 		if err := tmpl.ExecuteTemplate(w, "base", data); err != nil {
@@ -159,17 +352,108 @@ func (h *HttpServer) UI(w http.ResponseWriter, r *http.Request, ps httprouter.Pa
 			return
 		}
 
-		wifiHtml, err := db.GetWifiKeysAsHTML(h.Database)
+		wifiHtml, err := db.GetWifiKeysAsHTML(user.HasRole(db.RoleAdmin), h.Database)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		data := map[string]any{
+			"Title":       caser.String("tasks"),
+			"Name":        user.Name,
+			"Path":        r.URL.Path,
+			"CurrentUser": user,
+			"WifiKeys":    wifiHtml,
+		}
+
+		if err := tmpl.ExecuteTemplate(w, "base", data); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+
+	case "leases":
+		files := []string{"base.html", "leases.html"}
+		tmpl, err := parseTemplates(files...)
+		if err != nil {
+			if os.IsNotExist(err) {
+				http.NotFound(w, r)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		leases, err := db.GetLeases(h.Database)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
 		data := map[string]any{
-			"Title":    caser.String("tasks"),
-			"Name":     "User",
-			"Path":     r.URL.Path,
-			"WifiKeys": wifiHtml,
+			"Title":       caser.String("leases"),
+			"Name":        user.Name,
+			"Path":        r.URL.Path,
+			"CurrentUser": user,
+			"Leases":      leases,
+		}
+
+		if err := tmpl.ExecuteTemplate(w, "base", data); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+
+	case "users", "users/new":
+		files := []string{"base.html", "users.html"}
+		tmpl, err := parseTemplates(files...)
+		if err != nil {
+			if os.IsNotExist(err) {
+				http.NotFound(w, r)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		users, err := db.GetUsers(h.Database)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		data := map[string]any{
+			"Title":       caser.String("users"),
+			"Name":        user.Name,
+			"Path":        r.URL.Path,
+			"CurrentUser": user,
+			"Users":       users,
+			"Roles":       db.Roles,
+		}
+
+		if err := tmpl.ExecuteTemplate(w, "base", data); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+
+	case "tokens", "tokens/new":
+		h.renderTokens(w, r, "")
+
+	case "menus":
+		h.renderMenus(w, r, r.URL.Query().Get("menu"), "", "")
+
+	case "docs":
+		files := []string{"base.html", "docs.html"}
+		tmpl, err := parseTemplates(files...)
+		if err != nil {
+			if os.IsNotExist(err) {
+				http.NotFound(w, r)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		data := map[string]any{
+			"Title":       caser.String("api docs"),
+			"Name":        user.Name,
+			"Path":        r.URL.Path,
+			"CurrentUser": user,
 		}
 
 		if err := tmpl.ExecuteTemplate(w, "base", data); err != nil {
@@ -179,7 +463,7 @@ func (h *HttpServer) UI(w http.ResponseWriter, r *http.Request, ps httprouter.Pa
 	default:
 		if strings.HasPrefix(path, "tasks/edit/") {
 			id := ps.ByName("id")
-			files := []string{"base.html", "tasks_edit.html"}
+			files := []string{"base.html", "tasks_edit.html", "script_help.html"}
 			tmpl, err := parseTemplates(files...)
 			if err != nil {
 				if os.IsNotExist(err) {
@@ -196,11 +480,22 @@ func (h *HttpServer) UI(w http.ResponseWriter, r *http.Request, ps httprouter.Pa
 				return
 			}
 
+			// Tasks saved before scripts were checked, or with warnings,
+			// show their problems up front.
+			issues := db.CheckIPXEScript(task.Script)
+
 			data := map[string]any{
-				"Title": caser.String("edit task"),
-				"Name":  "User",
-				"Path":  r.URL.Path,
-				"Task":  task,
+				"Title":        caser.String("edit task"),
+				"Name":         user.Name,
+				"Path":         r.URL.Path,
+				"CurrentUser":  user,
+				"Task":         task,
+				"ScriptIssues": issues,
+				"ScriptErrors": db.HasScriptErrors(issues),
+			}
+			if err := h.taskHistoryData(r, task.ID, data); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
 			}
 
 			if err := tmpl.ExecuteTemplate(w, "base", data); err != nil {
@@ -232,12 +527,107 @@ func (h *HttpServer) UI(w http.ResponseWriter, r *http.Request, ps httprouter.Pa
 				return
 			}
 
+			// A host that has never sent a DHCP request has no lease.
+			lease, _ := db.GetLeaseByMAC(host.Mac, h.Database)
+
+			// The host can be pinned to any revision of its own task.
+			var revisions []db.TaskRevision
+			pinnedRevision := 0
+			if host.TaskID != nil {
+				revisions, err = db.GetTaskRevisions(*host.TaskID, h.Database)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+			}
+			if host.PinnedRevision != nil {
+				pinnedRevision = *host.PinnedRevision
+			}
+
+			// Previewing does not use up the host's task, so it is safe to
+			// render on every page load.
+			previewScope := r.URL.Query().Get("scope")
+			preview, previewWarning, scope, previewErr := h.previewScript(host.Mac, previewScope, r.Host)
+
+			data := map[string]any{
+				"Title":          caser.String("edit task"),
+				"Name":           user.Name,
+				"Path":           r.URL.Path,
+				"CurrentUser":    user,
+				"Host":           host,
+				"Tasks":          tasks,
+				"Lease":          lease,
+				"Subnets":        h.subnets(),
+				"Preview":        preview,
+				"PreviewWarning": previewWarning,
+				"PreviewScope":   scope.Name,
+				"ShowPreview":    previewScope != "",
+				"Scopes":         h.scopes(),
+				"Revisions":      revisions,
+				"PinnedRevision": pinnedRevision,
+			}
+			if previewErr != nil {
+				data["PreviewError"] = previewErr.Error()
+			}
+
+			if err := tmpl.ExecuteTemplate(w, "base", data); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		} else if strings.HasPrefix(path, "groups/edit/") {
+			id := ps.ByName("id")
+			files := []string{"base.html", "groups_edit.html"}
+			tmpl, err := parseTemplates(files...)
+			if err != nil {
+				if os.IsNotExist(err) {
+					http.NotFound(w, r)
+					return
+				}
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			group, err := db.GetHostGroupByID(id, h.Database)
+			if err != nil {
+				http.Error(w, "Group not found", http.StatusNotFound)
+				return
+			}
+
+			members, err := db.GetGroupMembers(group.ID, h.Database)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			tasks, err := db.GetTasks(h.Database)
+			if err != nil {
+				http.Error(w, "Tasks not found", http.StatusNotFound)
+				return
+			}
+			hosts, _, err := db.ListHosts(db.HostFilter{}, db.ListOptions{Limit: -1}, h.Database)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			memberIDs := map[uint]bool{}
+			for _, member := range members {
+				memberIDs[member.Host.ID] = true
+			}
+			var groupTaskID int
+			if group.TaskID != nil {
+				groupTaskID = *group.TaskID
+			}
+
 			data := map[string]any{
-				"Title": caser.String("edit task"),
-				"Name":  "User",
-				"Path":  r.URL.Path,
-				"Host":  host,
-				"Tasks": tasks,
+				"Title":       caser.String("edit group"),
+				"Name":        user.Name,
+				"Path":        r.URL.Path,
+				"CurrentUser": user,
+				"Group":       group,
+				"Members":     members,
+				"MemberIDs":   memberIDs,
+				"GroupTaskID": groupTaskID,
+				"Tasks":       tasks,
+				"Hosts":       hosts,
 			}
 
 			if err := tmpl.ExecuteTemplate(w, "base", data); err != nil {
@@ -264,10 +654,43 @@ func (h *HttpServer) UI(w http.ResponseWriter, r *http.Request, ps httprouter.Pa
 			}
 
 			data := map[string]any{
-				"Title": caser.String("edit wifi key"),
-				"Name":  "User",
-				"Path":  r.URL.Path,
-				"Key":   key,
+				"Title":       caser.String("edit wifi key"),
+				"Name":        user.Name,
+				"Path":        r.URL.Path,
+				"CurrentUser": user,
+				"Key":         key,
+			}
+
+			if err := tmpl.ExecuteTemplate(w, "base", data); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		} else if strings.HasPrefix(path, "users/edit/") {
+			id := ps.ByName("id")
+			files := []string{"base.html", "users_edit.html"}
+			tmpl, err := parseTemplates(files...)
+			if err != nil {
+				if os.IsNotExist(err) {
+					http.NotFound(w, r)
+					return
+				}
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			editUser, err := db.GetUserByID(id, h.Database)
+			if err != nil {
+				http.Error(w, "User not found", http.StatusNotFound)
+				return
+			}
+
+			data := map[string]any{
+				"Title":       caser.String("edit user"),
+				"Name":        user.Name,
+				"Path":        r.URL.Path,
+				"CurrentUser": user,
+				"User":        editUser,
+				"Roles":       db.Roles,
 			}
 
 			if err := tmpl.ExecuteTemplate(w, "base", data); err != nil {
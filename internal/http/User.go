@@ -0,0 +1,87 @@
+package httpserver
+
+import (
+	"net/http"
+	"pxehub/internal/db"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func (h *HttpServer) NewUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	username := r.FormValue("username")
+	name := r.FormValue("name")
+	password := r.FormValue("password")
+	role := r.FormValue("role")
+	redirect := r.FormValue("redirect") == "true"
+
+	if username == "" || password == "" || role == "" {
+		http.Error(w, "Missing fields", http.StatusBadRequest)
+		return
+	}
+
+	if err := db.CreateUser(username, name, password, role, h.Database); err != nil {
+		http.Error(w, "Create failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if redirect {
+		http.Redirect(w, r, "/users", http.StatusSeeOther)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"ok"}`))
+	}
+}
+
+func (h *HttpServer) EditUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+	var idPtr uint
+	if id != "" {
+		idInt, err := strconv.Atoi(id)
+		if err != nil {
+			http.Error(w, "Invalid userID", http.StatusBadRequest)
+			return
+		}
+		idPtr = uint(idInt)
+	}
+
+	name := r.FormValue("name")
+	password := r.FormValue("password")
+	role := r.FormValue("role")
+	redirect := r.FormValue("redirect") == "true"
+
+	if err := db.EditUser(name, password, role, idPtr, h.Database); err != nil {
+		http.Error(w, "Update failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if redirect {
+		http.Redirect(w, r, "/users", http.StatusSeeOther)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"ok"}`))
+	}
+}
+
+func (h *HttpServer) DeleteUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+
+	redirect := r.FormValue("redirect") == "true"
+
+	if id == strconv.Itoa(int(currentUser(r).ID)) {
+		http.Error(w, "Cannot delete the logged in user", http.StatusBadRequest)
+		return
+	}
+
+	if err := db.DeleteUser(id, h.Database); err != nil {
+		http.Error(w, "Update failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if redirect {
+		http.Redirect(w, r, "/users", http.StatusSeeOther)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"ok"}`))
+	}
+}
@@ -32,7 +32,7 @@ func (h *HttpServer) GetWifiKey(w http.ResponseWriter, r *http.Request, ps httpr
 		return
 	}
 
-	err = db.LogRequest(true, time.Now(), host.Mac, h.Database)
+	err = db.LogRequest(true, time.Now(), host.Mac, h.scopeFor(r, host.Mac).Name, h.Database)
 	if err != nil {
 		http.Error(w, "Logging Request Failed: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -50,7 +50,10 @@ func (h *HttpServer) NewWifiKey(w http.ResponseWriter, r *http.Request, ps httpr
 		return
 	}
 
-	db.CreateWifiKey(key, h.Database)
+	if _, err := db.CreateWifiKey(key, h.Database); err != nil {
+		http.Error(w, "Create failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	if redirect {
 		http.Redirect(w, r, "/wifikeys", http.StatusSeeOther)
@@ -0,0 +1,66 @@
+package ipxe
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// maxBinarySize bounds downloads, iPXE binaries are well under a megabyte.
+const maxBinarySize = 32 << 20
+
+var httpClient = &http.Client{Timeout: 60 * time.Second}
+
+// download fetches url into dest. If the cached copy in dest is unpinned, the
+// request is conditional on it being older than the remote file.
+func download(url, dest, name string, cached bool, opts Options) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	if cached && opts.SHA256[name] == "" {
+		if info, err := os.Stat(dest); err == nil {
+			req.Header.Set("If-Modified-Since", info.ModTime().UTC().Format(http.TimeFormat))
+		}
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && cached {
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download %s: %s", url, resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBinarySize+1))
+	if err != nil {
+		return fmt.Errorf("download %s: %w", url, err)
+	}
+	if len(data) > maxBinarySize {
+		return fmt.Errorf("download %s: larger than %d bytes", url, maxBinarySize)
+	}
+
+	if err := opts.verify(name, data); err != nil {
+		return err
+	}
+
+	if err := writeFile(dest, data); err != nil {
+		return err
+	}
+
+	if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		os.Chtimes(dest, modified, modified)
+	}
+
+	log.Printf("Downloaded %s from %s", name, url)
+	return nil
+}
@@ -0,0 +1,12 @@
+package ipxe
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed bin
+var embeddedFiles embed.FS
+
+// embedded holds the iPXE binaries built into pxehub, if any.
+var embedded, _ = fs.Sub(embeddedFiles, "bin")
@@ -0,0 +1,201 @@
+package ipxe
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ChainScript is served to clients already running iPXE, and chains to the
+// pxehub boot script for their MAC address.
+const ChainScript = "autoexec.ipxe"
+
+// DefaultBootFiles maps the DHCP client architecture (option 93) to the
+// iPXE binary it should load, unless BOOT_FILES is configured. EFI IA32
+// (6) has no standard build to download, so it is left out.
+var DefaultBootFiles = map[uint16]string{
+	0:  "ipxe.pxe", // x86 BIOS
+	1:  "ipxe.pxe",
+	7:  "ipxe.efi",       // EFI x64
+	9:  "ipxe.efi",       // EFI x86-64
+	11: "ipxe-arm64.efi", // EFI ARM64
+}
+
+// DownloadURLs are where binaries missing from every local source are
+// fetched from. Boot files not listed here must be provided locally.
+var DownloadURLs = map[string]string{
+	"ipxe.pxe":          "https://boot.ipxe.org/ipxe.pxe",
+	"ipxe.efi":          "https://boot.ipxe.org/ipxe.efi",
+	"undionly.kpxe":     "https://boot.ipxe.org/undionly.kpxe",
+	"snponly.efi":       "https://boot.ipxe.org/snponly.efi",
+	"ipxe-arm64.efi":    "https://boot.ipxe.org/arm64-efi/ipxe.efi",
+	"snponly-arm64.efi": "https://boot.ipxe.org/arm64-efi/snponly.efi",
+}
+
+// Options controls where PrepareTFTP gets the iPXE binaries from.
+type Options struct {
+	// Offline never downloads. The binaries must already be in the TFTP
+	// directory, in SourceDir, or embedded.
+	Offline bool
+	// SourceDir is a directory of binaries placed there by hand. They are
+	// preferred over embedded and downloaded copies.
+	SourceDir string
+	// SHA256 pins binaries by name to a hex encoded digest. Copies that do
+	// not match are never served.
+	SHA256 map[string]string
+}
+
+var ErrChecksumMismatch = errors.New("sha256 mismatch")
+
+// BootFile returns the file from files a PXE client should load, or "" if
+// its architecture has none. Clients whose user class is iPXE get
+// ChainScript.
+func BootFile(files map[uint16]string, arch uint16, hasArch bool, userClass string) string {
+	if strings.Contains(userClass, "iPXE") {
+		return ChainScript
+	}
+	if !hasArch {
+		return ""
+	}
+	return files[arch]
+}
+
+// verify checks data against the pinned digest, if there is one.
+func (o Options) verify(name string, data []byte) error {
+	want := strings.ToLower(o.SHA256[name])
+	if want == "" {
+		return nil
+	}
+
+	sum := sha256.Sum256(data)
+	if got := hex.EncodeToString(sum[:]); got != want {
+		return fmt.Errorf("%s: %w: got %s, want %s", name, ErrChecksumMismatch, got, want)
+	}
+	return nil
+}
+
+// writeFile replaces dest atomically, so a failed write never leaves a
+// truncated binary behind.
+func writeFile(dest string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), dest)
+}
+
+// readLocal reads name from fsys, returning nil if it is missing or does not
+// match its pinned digest.
+func (o Options) readLocal(fsys fs.FS, name, source string) []byte {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil
+	}
+	if err := o.verify(name, data); err != nil {
+		log.Printf("Ignoring %s from %s: %v", name, source, err)
+		return nil
+	}
+	return data
+}
+
+// prepareBinary makes sure dir contains a usable copy of name.
+func prepareBinary(dir, name string, opts Options) error {
+	dest := filepath.Join(dir, name)
+	pinned := opts.SHA256[name] != ""
+	cached := opts.readLocal(os.DirFS(dir), name, "cache")
+
+	// A pinned copy can't be outdated.
+	if pinned && cached != nil {
+		return nil
+	}
+
+	if opts.SourceDir != "" {
+		if data := opts.readLocal(os.DirFS(opts.SourceDir), name, opts.SourceDir); data != nil {
+			log.Printf("Using %s from %s", name, opts.SourceDir)
+			return writeFile(dest, data)
+		}
+	}
+
+	if data := opts.readLocal(embedded, name, "embedded binaries"); data != nil {
+		log.Printf("Using embedded %s", name)
+		return writeFile(dest, data)
+	}
+
+	url, ok := DownloadURLs[name]
+	if !ok {
+		// Custom builds have nothing to download.
+		if cached != nil {
+			return nil
+		}
+		return fmt.Errorf("boot file %s not found in %s", name, dir)
+	}
+
+	var downloadErr error
+	if opts.Offline {
+		downloadErr = errors.New("downloads disabled in offline mode")
+	} else if downloadErr = download(url, dest, name, cached != nil, opts); downloadErr == nil {
+		return nil
+	}
+
+	if cached != nil {
+		log.Printf("Using cached %s: %v", name, downloadErr)
+		return nil
+	}
+
+	return fmt.Errorf("no usable copy of boot file %s in %s: %w", name, dir, downloadErr)
+}
+
+// PrepareTFTP makes sure every boot file in files is in dir, and writes
+// ChainScript. The same script can be built into iPXE with EMBED=, so clients
+// need not fetch it over TFTP.
+func PrepareTFTP(dir string, files map[uint16]string, opts Options) error {
+	names := slices.Sorted(maps.Values(files))
+	for _, name := range slices.Compact(names) {
+		if err := prepareBinary(dir, name, opts); err != nil {
+			return err
+		}
+	}
+
+	// With proxy DHCP, next-server comes from the proxy rather than the
+	// DHCP server. Setting it globally lets later scripts use it as is.
+	script := `#!ipxe
+dhcp
+isset ${proxydhcp/next-server} || goto chain
+set next-server ${proxydhcp/next-server}
+:chain
+chain --autofree http://${next-server}/api/boot/${net0/mac}
+	`
+
+	scriptPath := filepath.Join(dir, ChainScript)
+
+	if err := os.WriteFile(scriptPath, []byte(script), 0644); err != nil {
+		return err
+	}
+
+	return nil
+}
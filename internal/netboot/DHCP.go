@@ -0,0 +1,187 @@
+package netboot
+
+import (
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"pxehub/internal/ipxe"
+)
+
+const leaseTime = 12 * time.Hour
+
+func (s *NetbootServer) serveDHCP() {
+	defer s.wg.Done()
+
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := s.dhcpConn.ReadFrom(buf)
+		if err != nil {
+			if s.stopping() {
+				return
+			}
+			log.Printf("dhcp read error: %v", err)
+			continue
+		}
+
+		req, err := parsePacket(buf[:n])
+		if err != nil || req.Op != bootRequest || req.HLen != 6 {
+			continue
+		}
+
+		s.handleDHCP(req)
+	}
+}
+
+func (s *NetbootServer) handleDHCP(req *packet) {
+	mac := req.CHAddr.String()
+
+	switch req.messageType() {
+	case dhcpDiscover:
+		ip, err := s.pool.offer(mac, req.ipOption(optRequestedIP))
+		if err != nil {
+			log.Printf("DHCPDISCOVER(%s) %s: %v", s.Iface, mac, err)
+			return
+		}
+		log.Printf("DHCPOFFER(%s) %s %s", s.Iface, ip, mac)
+		s.sendDHCP(req, s.reply(req, dhcpOffer, ip))
+
+	case dhcpRequest:
+		// A server identifier for another server means the client chose
+		// someone else's offer.
+		if id := req.ipOption(optServerID); id != nil && !id.Equal(s.serverIP) {
+			s.pool.release(mac)
+			return
+		}
+
+		ip := req.ipOption(optRequestedIP)
+		if ip == nil {
+			ip = req.CIAddr
+		}
+
+		if !s.pool.commit(mac, ip, leaseTime) {
+			log.Printf("DHCPNAK(%s) %s %s", s.Iface, ip, mac)
+			s.sendDHCP(req, s.reply(req, dhcpNak, nil))
+			return
+		}
+
+		log.Printf("DHCPACK(%s) %s %s", s.Iface, ip, mac)
+		s.sendDHCP(req, s.reply(req, dhcpAck, ip))
+		s.emitLease(req, ip, time.Now().Add(leaseTime))
+
+	case dhcpRelease:
+		log.Printf("DHCPRELEASE(%s) %s %s", s.Iface, req.CIAddr, mac)
+		s.pool.release(mac)
+		s.emitLease(req, req.CIAddr, time.Now())
+
+	case dhcpDecline:
+		ip := req.ipOption(optRequestedIP)
+		log.Printf("DHCPDECLINE(%s) %s %s", s.Iface, ip, mac)
+		s.pool.decline(mac, ip)
+
+	case dhcpInform:
+		s.sendDHCP(req, s.reply(req, dhcpAck, nil))
+	}
+}
+
+// reply builds a response to req. yiaddr is nil for NAKs and INFORM ACKs.
+func (s *NetbootServer) reply(req *packet, msgType byte, yiaddr net.IP) *packet {
+	resp := &packet{
+		Op:      bootReply,
+		HType:   req.HType,
+		HLen:    req.HLen,
+		XID:     req.XID,
+		Flags:   req.Flags,
+		CIAddr:  net.IPv4zero,
+		YIAddr:  net.IPv4zero,
+		SIAddr:  net.IPv4zero,
+		GIAddr:  req.GIAddr,
+		CHAddr:  req.CHAddr,
+		Options: map[byte][]byte{},
+	}
+	resp.Options[optMessageType] = []byte{msgType}
+	resp.Options[optServerID] = s.serverIP.To4()
+
+	if msgType == dhcpNak {
+		return resp
+	}
+
+	if yiaddr != nil {
+		resp.YIAddr = yiaddr
+		resp.Options[optLeaseTime] = uint32Option(uint32(leaseTime.Seconds()))
+		resp.Options[optRenewalTime] = uint32Option(uint32(leaseTime.Seconds() / 2))
+		resp.Options[optRebindingTime] = uint32Option(uint32(leaseTime.Seconds() * 7 / 8))
+	} else {
+		resp.CIAddr = req.CIAddr
+	}
+
+	resp.Options[optSubnetMask] = s.mask
+	if router := net.ParseIP(s.Router); router != nil {
+		resp.Options[optRouter] = router.To4()
+	}
+	if hostname := s.pool.hostname(req.CHAddr.String()); hostname != "" {
+		resp.Options[optHostname] = []byte(hostname)
+	}
+	if len(s.Nameservers) > 0 {
+		var dns []net.IP
+		for _, ns := range s.Nameservers {
+			dns = append(dns, net.ParseIP(ns))
+		}
+		resp.Options[optDNS] = ipListOption(dns)
+	}
+
+	arch, hasArch := req.clientArch()
+	if file := ipxe.BootFile(s.BootFiles, arch, hasArch, req.userClass()); file != "" {
+		resp.SIAddr = s.serverIP
+		resp.File = file
+		resp.Options[optTFTPServer] = []byte(s.serverIP.String())
+		resp.Options[optBootFile] = []byte(file)
+	}
+
+	// PXE ROMs only accept offers that identify as a PXE server. Discovery
+	// control bit 3 tells them to boot the file without PXE discovery.
+	if strings.HasPrefix(string(req.Options[optVendorClass]), "PXEClient") {
+		resp.Options[optVendorClass] = []byte("PXEClient")
+		resp.Options[optVendorOpts] = []byte{6, 1, 8, optEnd}
+	}
+
+	return resp
+}
+
+// sendDHCP delivers resp to a relay, to the client's existing address, or
+// by broadcast, following RFC 2131 section 4.1.
+func (s *NetbootServer) sendDHCP(req, resp *packet) {
+	dest := &net.UDPAddr{IP: net.IPv4bcast, Port: 68}
+	switch {
+	case !req.GIAddr.Equal(net.IPv4zero):
+		dest = &net.UDPAddr{IP: req.GIAddr, Port: 67}
+	case !req.CIAddr.Equal(net.IPv4zero) && resp.messageType() != dhcpNak:
+		dest = &net.UDPAddr{IP: req.CIAddr, Port: 68}
+	}
+
+	if _, err := s.dhcpConn.WriteTo(resp.marshal(), dest); err != nil {
+		log.Printf("dhcp write error: %v", err)
+	}
+}
+
+func (s *NetbootServer) emitLease(req *packet, ip net.IP, expires time.Time) {
+	if s.OnLease == nil {
+		return
+	}
+
+	lease := Lease{
+		Scope:       s.Name,
+		Mac:         req.CHAddr.String(),
+		IP:          ip.String(),
+		Hostname:    string(req.Options[optHostname]),
+		VendorClass: string(req.Options[optVendorClass]),
+		ClientArch:  -1,
+		Expires:     expires,
+	}
+	if arch, ok := req.clientArch(); ok {
+		lease.ClientArch = int(arch)
+	}
+
+	s.OnLease(lease)
+}
@@ -0,0 +1,223 @@
+package netboot
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errPoolExhausted = errors.New("no free addresses in range")
+
+// offerTTL is how long an offered address is held for a client that has not
+// yet requested it.
+const offerTTL = time.Minute
+
+// declineTTL is how long an address declined by a client is left unused.
+const declineTTL = 10 * time.Minute
+
+type poolLease struct {
+	mac     string
+	ip      uint32
+	expires time.Time
+}
+
+// leasePool hands out addresses from an inclusive range, keeping at most
+// one lease per MAC address.
+type leasePool struct {
+	mu       sync.Mutex
+	start    uint32
+	end      uint32
+	byMAC    map[string]*poolLease
+	byIP     map[uint32]*poolLease
+	declined map[uint32]time.Time
+
+	// reserved maps MAC addresses to their reserved IP, and reservedBy the
+	// other way round. Reserved addresses may lie outside the range.
+	reserved   map[string]uint32
+	reservedBy map[uint32]string
+	hostnames  map[string]string
+}
+
+func ipToUint(ip net.IP) uint32 {
+	v4 := ip.To4()
+	if v4 == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(v4)
+}
+
+func uintToIP(v uint32) net.IP {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, v)
+	return ip
+}
+
+func newLeasePool(start, end net.IP) (*leasePool, error) {
+	s, e := ipToUint(start), ipToUint(end)
+	if s == 0 || e == 0 || s > e {
+		return nil, errors.New("invalid dhcp range")
+	}
+
+	return &leasePool{
+		start:    s,
+		end:      e,
+		byMAC:    map[string]*poolLease{},
+		byIP:     map[uint32]*poolLease{},
+		declined: map[uint32]time.Time{},
+	}, nil
+}
+
+// reserve replaces the pool's reservations.
+func (p *leasePool) reserve(reservations []Reservation) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.reserved = map[string]uint32{}
+	p.reservedBy = map[uint32]string{}
+	p.hostnames = map[string]string{}
+
+	for _, r := range reservations {
+		mac := strings.ToLower(r.Mac)
+		if r.Hostname != "" {
+			p.hostnames[mac] = r.Hostname
+		}
+		if ip := ipToUint(net.ParseIP(r.IP)); ip != 0 {
+			p.reserved[mac] = ip
+			p.reservedBy[ip] = mac
+		}
+	}
+}
+
+// load adds leases handed out before the pool was made, such as by an
+// earlier run, so their addresses are not offered to other clients. Leases
+// outside the range or already expired are skipped.
+func (p *leasePool) load(leases []Lease) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for _, l := range leases {
+		ip := ipToUint(net.ParseIP(l.IP))
+		if !p.inRange(ip) || !l.Expires.After(now) {
+			continue
+		}
+		p.assign(strings.ToLower(l.Mac), ip, l.Expires)
+	}
+}
+
+// hostname returns the reserved hostname for mac, if any.
+func (p *leasePool) hostname(mac string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.hostnames[mac]
+}
+
+func (p *leasePool) inRange(ip uint32) bool {
+	return ip >= p.start && ip <= p.end
+}
+
+// free reports whether ip can be given to mac. Callers must hold p.mu.
+func (p *leasePool) free(ip uint32, mac string, now time.Time) bool {
+	if owner, ok := p.reservedBy[ip]; ok && owner != mac {
+		return false
+	}
+	if until, ok := p.declined[ip]; ok && now.Before(until) {
+		return false
+	}
+	l, ok := p.byIP[ip]
+	return !ok || l.mac == mac || now.After(l.expires)
+}
+
+func (p *leasePool) assign(mac string, ip uint32, expires time.Time) {
+	if old, ok := p.byMAC[mac]; ok {
+		delete(p.byIP, old.ip)
+	}
+	if old, ok := p.byIP[ip]; ok {
+		delete(p.byMAC, old.mac)
+	}
+	l := &poolLease{mac: mac, ip: ip, expires: expires}
+	p.byMAC[mac] = l
+	p.byIP[ip] = l
+}
+
+// offer picks an address for mac, preferring its reservation, then its
+// current lease, then the address it asked for, then the lowest free address.
+func (p *leasePool) offer(mac string, requested net.IP) (net.IP, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	expires := now.Add(offerTTL)
+
+	if ip, ok := p.reserved[mac]; ok {
+		p.assign(mac, ip, expires)
+		return uintToIP(ip), nil
+	}
+
+	if l, ok := p.byMAC[mac]; ok && p.free(l.ip, mac, now) {
+		if l.expires.Before(expires) {
+			l.expires = expires
+		}
+		return uintToIP(l.ip), nil
+	}
+
+	if req := ipToUint(requested); p.inRange(req) && p.free(req, mac, now) {
+		p.assign(mac, req, expires)
+		return uintToIP(req), nil
+	}
+
+	for ip := p.start; ip <= p.end && ip != 0; ip++ {
+		if p.free(ip, mac, now) {
+			p.assign(mac, ip, expires)
+			return uintToIP(ip), nil
+		}
+	}
+
+	return nil, errPoolExhausted
+}
+
+// commit confirms a lease on ip for mac, returning false if the address is
+// outside the range or held by another client.
+func (p *leasePool) commit(mac string, ip net.IP, ttl time.Duration) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	v := ipToUint(ip)
+	now := time.Now()
+	if reserved, ok := p.reserved[mac]; ok {
+		if v != reserved {
+			return false
+		}
+	} else if !p.inRange(v) || !p.free(v, mac, now) {
+		return false
+	}
+
+	p.assign(mac, v, now.Add(ttl))
+	return true
+}
+
+func (p *leasePool) release(mac string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if l, ok := p.byMAC[mac]; ok {
+		delete(p.byIP, l.ip)
+		delete(p.byMAC, mac)
+	}
+}
+
+func (p *leasePool) decline(mac string, ip net.IP) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	v := ipToUint(ip)
+	if l, ok := p.byIP[v]; ok && l.mac == mac {
+		delete(p.byIP, v)
+		delete(p.byMAC, mac)
+	}
+	p.declined[v] = time.Now().Add(declineTTL)
+}
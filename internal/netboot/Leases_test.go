@@ -0,0 +1,180 @@
+package netboot
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+const (
+	macA = "aa:aa:aa:aa:aa:aa"
+	macB = "bb:bb:bb:bb:bb:bb"
+	macC = "cc:cc:cc:cc:cc:cc"
+	macD = "dd:dd:dd:dd:dd:dd"
+)
+
+// poolStep is one call on a lease pool. Offers pass ip as the requested
+// address and want the address want, or errPoolExhausted if want is empty.
+// Commits want ok.
+type poolStep struct {
+	op   string
+	mac  string
+	ip   string
+	want string
+	ok   bool
+}
+
+func TestLeasePool(t *testing.T) {
+	tests := []struct {
+		name         string
+		reservations []Reservation
+		leases       []Lease
+		steps        []poolStep
+	}{
+		{"lowest free address", nil, nil, []poolStep{
+			{op: "offer", mac: macA, want: "10.0.0.10"},
+			{op: "offer", mac: macB, want: "10.0.0.11"},
+			{op: "offer", mac: macA, want: "10.0.0.10"},
+		}},
+		{"exhausted", nil, nil, []poolStep{
+			{op: "offer", mac: macA, want: "10.0.0.10"},
+			{op: "offer", mac: macB, want: "10.0.0.11"},
+			{op: "offer", mac: macC, want: "10.0.0.12"},
+			{op: "offer", mac: macD, want: ""},
+		}},
+		{"requested address", nil, nil, []poolStep{
+			{op: "offer", mac: macA, ip: "10.0.0.12", want: "10.0.0.12"},
+			{op: "offer", mac: macB, ip: "10.0.0.12", want: "10.0.0.10"},
+			{op: "offer", mac: macC, ip: "10.0.0.99", want: "10.0.0.11"},
+		}},
+		{"expired offer", nil, []Lease{{Mac: macA, IP: "10.0.0.10", Expires: time.Now().Add(time.Millisecond)}}, []poolStep{
+			{op: "wait"},
+			{op: "offer", mac: macB, want: "10.0.0.10"},
+		}},
+		{"commit", nil, nil, []poolStep{
+			{op: "offer", mac: macA, want: "10.0.0.10"},
+			{op: "commit", mac: macA, ip: "10.0.0.10", ok: true},
+			{op: "commit", mac: macB, ip: "10.0.0.10", ok: false},
+			{op: "commit", mac: macB, ip: "10.0.0.99", ok: false},
+			{op: "commit", mac: macB, ip: "10.0.0.12", ok: true},
+			{op: "offer", mac: macC, want: "10.0.0.11"},
+		}},
+		{"commit moves lease", nil, nil, []poolStep{
+			{op: "commit", mac: macA, ip: "10.0.0.10", ok: true},
+			{op: "commit", mac: macA, ip: "10.0.0.11", ok: true},
+			{op: "offer", mac: macB, want: "10.0.0.10"},
+		}},
+		{"release", nil, nil, []poolStep{
+			{op: "commit", mac: macA, ip: "10.0.0.10", ok: true},
+			{op: "release", mac: macA},
+			{op: "offer", mac: macB, want: "10.0.0.10"},
+		}},
+		{"decline", nil, nil, []poolStep{
+			{op: "offer", mac: macA, want: "10.0.0.10"},
+			{op: "decline", mac: macA, ip: "10.0.0.10"},
+			{op: "offer", mac: macA, want: "10.0.0.11"},
+			{op: "offer", mac: macB, want: "10.0.0.12"},
+			{op: "commit", mac: macC, ip: "10.0.0.10", ok: false},
+		}},
+		{"decline of another client's lease", nil, nil, []poolStep{
+			{op: "commit", mac: macA, ip: "10.0.0.10", ok: true},
+			{op: "decline", mac: macB, ip: "10.0.0.10"},
+			{op: "offer", mac: macA, want: "10.0.0.11"},
+		}},
+		{"reservation", []Reservation{{Mac: "AA:AA:AA:AA:AA:AA", IP: "10.0.0.11"}}, nil, []poolStep{
+			{op: "offer", mac: macB, want: "10.0.0.10"},
+			{op: "offer", mac: macC, want: "10.0.0.12"},
+			{op: "offer", mac: macD, ip: "10.0.0.11", want: ""},
+			{op: "offer", mac: macA, ip: "10.0.0.12", want: "10.0.0.11"},
+			{op: "commit", mac: macA, ip: "10.0.0.10", ok: false},
+			{op: "commit", mac: macA, ip: "10.0.0.11", ok: true},
+			{op: "commit", mac: macB, ip: "10.0.0.11", ok: false},
+		}},
+		{"reservation outside range", []Reservation{{Mac: macA, IP: "10.0.1.5"}}, nil, []poolStep{
+			{op: "offer", mac: macA, want: "10.0.1.5"},
+			{op: "commit", mac: macA, ip: "10.0.1.5", ok: true},
+		}},
+		{"hostname only reservation", []Reservation{{Mac: macA, Hostname: "pc01"}}, nil, []poolStep{
+			{op: "offer", mac: macA, want: "10.0.0.10"},
+		}},
+		{"loaded leases", nil, []Lease{
+			{Mac: "AA:AA:AA:AA:AA:AA", IP: "10.0.0.10", Expires: time.Now().Add(time.Hour)},
+			{Mac: macB, IP: "10.0.0.11", Expires: time.Now().Add(-time.Hour)},
+			{Mac: macC, IP: "10.0.0.99", Expires: time.Now().Add(time.Hour)},
+		}, []poolStep{
+			{op: "offer", mac: macD, want: "10.0.0.11"},
+			{op: "offer", mac: macA, want: "10.0.0.10"},
+			{op: "offer", mac: macC, want: "10.0.0.12"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool, err := newLeasePool(net.ParseIP("10.0.0.10"), net.ParseIP("10.0.0.12"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			pool.reserve(tt.reservations)
+			pool.load(tt.leases)
+
+			for i, step := range tt.steps {
+				switch step.op {
+				case "offer":
+					ip, err := pool.offer(step.mac, net.ParseIP(step.ip))
+					if step.want == "" {
+						if !errors.Is(err, errPoolExhausted) {
+							t.Fatalf("step %d: %s was offered %v, %v, want errPoolExhausted", i, step.mac, ip, err)
+						}
+					} else if err != nil || !ip.Equal(net.ParseIP(step.want)) {
+						t.Fatalf("step %d: %s was offered %v, %v, want %s", i, step.mac, ip, err, step.want)
+					}
+				case "commit":
+					if ok := pool.commit(step.mac, net.ParseIP(step.ip), time.Hour); ok != step.ok {
+						t.Fatalf("step %d: commit of %s for %s = %v, want %v", i, step.ip, step.mac, ok, step.ok)
+					}
+				case "decline":
+					pool.decline(step.mac, net.ParseIP(step.ip))
+				case "release":
+					pool.release(step.mac)
+				case "wait":
+					time.Sleep(5 * time.Millisecond)
+				}
+			}
+		})
+	}
+}
+
+func TestLeasePoolHostname(t *testing.T) {
+	pool, err := newLeasePool(net.ParseIP("10.0.0.10"), net.ParseIP("10.0.0.12"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.reserve([]Reservation{{Mac: "AA:AA:AA:AA:AA:AA", Hostname: "pc01"}})
+
+	if got := pool.hostname(macA); got != "pc01" {
+		t.Fatalf("hostname(%s) = %q, want pc01", macA, got)
+	}
+	if got := pool.hostname(macB); got != "" {
+		t.Fatalf("hostname(%s) = %q, want none", macB, got)
+	}
+}
+
+func TestNewLeasePool(t *testing.T) {
+	tests := []struct {
+		start, end string
+		valid      bool
+	}{
+		{"10.0.0.10", "10.0.0.20", true},
+		{"10.0.0.10", "10.0.0.10", true},
+		{"10.0.0.20", "10.0.0.10", false},
+		{"::1", "10.0.0.10", false},
+	}
+
+	for _, tt := range tests {
+		_, err := newLeasePool(net.ParseIP(tt.start), net.ParseIP(tt.end))
+		if (err == nil) != tt.valid {
+			t.Errorf("newLeasePool(%s, %s) error = %v, want valid %v", tt.start, tt.end, err, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package netboot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sort"
+)
+
+// DHCP message types (option 53).
+const (
+	dhcpDiscover = 1
+	dhcpOffer    = 2
+	dhcpRequest  = 3
+	dhcpDecline  = 4
+	dhcpAck      = 5
+	dhcpNak      = 6
+	dhcpRelease  = 7
+	dhcpInform   = 8
+)
+
+// DHCP option codes.
+const (
+	optSubnetMask    = 1
+	optRouter        = 3
+	optDNS           = 6
+	optHostname      = 12
+	optVendorOpts    = 43
+	optRequestedIP   = 50
+	optLeaseTime     = 51
+	optMessageType   = 53
+	optServerID      = 54
+	optRenewalTime   = 58
+	optRebindingTime = 59
+	optVendorClass   = 60
+	optTFTPServer    = 66
+	optBootFile      = 67
+	optUserClass     = 77
+	optClientArch    = 93
+	optEnd           = 255
+	optPad           = 0
+)
+
+const bootRequest = 1
+const bootReply = 2
+
+var magicCookie = []byte{99, 130, 83, 99}
+
+var errShortPacket = errors.New("dhcp packet too short")
+var errBadCookie = errors.New("dhcp packet has no magic cookie")
+
+// packet is a DHCPv4 message (RFC 2131).
+type packet struct {
+	Op      byte
+	HType   byte
+	HLen    byte
+	Hops    byte
+	XID     uint32
+	Secs    uint16
+	Flags   uint16
+	CIAddr  net.IP
+	YIAddr  net.IP
+	SIAddr  net.IP
+	GIAddr  net.IP
+	CHAddr  net.HardwareAddr
+	File    string
+	Options map[byte][]byte
+}
+
+func parsePacket(b []byte) (*packet, error) {
+	if len(b) < 240 {
+		return nil, errShortPacket
+	}
+	if !bytes.Equal(b[236:240], magicCookie) {
+		return nil, errBadCookie
+	}
+
+	p := &packet{
+		Op:      b[0],
+		HType:   b[1],
+		HLen:    b[2],
+		Hops:    b[3],
+		XID:     binary.BigEndian.Uint32(b[4:8]),
+		Secs:    binary.BigEndian.Uint16(b[8:10]),
+		Flags:   binary.BigEndian.Uint16(b[10:12]),
+		CIAddr:  net.IP(append([]byte(nil), b[12:16]...)),
+		YIAddr:  net.IP(append([]byte(nil), b[16:20]...)),
+		SIAddr:  net.IP(append([]byte(nil), b[20:24]...)),
+		GIAddr:  net.IP(append([]byte(nil), b[24:28]...)),
+		Options: map[byte][]byte{},
+	}
+
+	hlen := int(p.HLen)
+	if hlen > 16 {
+		hlen = 16
+	}
+	p.CHAddr = net.HardwareAddr(append([]byte(nil), b[28:28+hlen]...))
+
+	opts := b[240:]
+	for i := 0; i < len(opts); {
+		code := opts[i]
+		if code == optEnd {
+			break
+		}
+		if code == optPad {
+			i++
+			continue
+		}
+		if i+1 >= len(opts) {
+			break
+		}
+		length := int(opts[i+1])
+		if i+2+length > len(opts) {
+			break
+		}
+		// Repeated options are concatenated (RFC 3396).
+		p.Options[code] = append(p.Options[code], opts[i+2:i+2+length]...)
+		i += 2 + length
+	}
+
+	return p, nil
+}
+
+func (p *packet) marshal() []byte {
+	b := make([]byte, 240, 576)
+	b[0] = p.Op
+	b[1] = p.HType
+	b[2] = p.HLen
+	b[3] = p.Hops
+	binary.BigEndian.PutUint32(b[4:8], p.XID)
+	binary.BigEndian.PutUint16(b[8:10], p.Secs)
+	binary.BigEndian.PutUint16(b[10:12], p.Flags)
+	copy(b[12:16], p.CIAddr.To4())
+	copy(b[16:20], p.YIAddr.To4())
+	copy(b[20:24], p.SIAddr.To4())
+	copy(b[24:28], p.GIAddr.To4())
+	copy(b[28:44], p.CHAddr)
+	copy(b[108:236], p.File)
+	copy(b[236:240], magicCookie)
+
+	codes := make([]int, 0, len(p.Options))
+	for code := range p.Options {
+		codes = append(codes, int(code))
+	}
+	sort.Ints(codes)
+
+	for _, code := range codes {
+		val := p.Options[byte(code)]
+		for len(val) > 255 {
+			b = append(b, byte(code), 255)
+			b = append(b, val[:255]...)
+			val = val[255:]
+		}
+		b = append(b, byte(code), byte(len(val)))
+		b = append(b, val...)
+	}
+	b = append(b, optEnd)
+
+	// Some PXE ROMs drop replies shorter than a BOOTP packet.
+	for len(b) < 300 {
+		b = append(b, optPad)
+	}
+
+	return b
+}
+
+func (p *packet) messageType() byte {
+	if val := p.Options[optMessageType]; len(val) == 1 {
+		return val[0]
+	}
+	return 0
+}
+
+func (p *packet) ipOption(code byte) net.IP {
+	if val := p.Options[code]; len(val) == 4 {
+		return net.IP(val)
+	}
+	return nil
+}
+
+// clientArch returns option 93, if the client sent it.
+func (p *packet) clientArch() (uint16, bool) {
+	if val := p.Options[optClientArch]; len(val) >= 2 {
+		return binary.BigEndian.Uint16(val[:2]), true
+	}
+	return 0, false
+}
+
+// userClass returns option 77. iPXE sends it as a plain string, RFC 3004
+// clients as length prefixed strings, which still contain the class name.
+func (p *packet) userClass() string {
+	return string(p.Options[optUserClass])
+}
+
+func uint32Option(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func ipListOption(ips []net.IP) []byte {
+	var b []byte
+	for _, ip := range ips {
+		if v4 := ip.To4(); v4 != nil {
+			b = append(b, v4...)
+		}
+	}
+	return b
+}
@@ -0,0 +1,148 @@
+package netboot
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestPacketRoundTrip(t *testing.T) {
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:ff")
+	tests := []struct {
+		name    string
+		options map[byte][]byte
+	}{
+		{"no options", map[byte][]byte{}},
+		{"discover", map[byte][]byte{
+			optMessageType: {dhcpDiscover},
+			optClientArch:  {0, 7},
+			optUserClass:   []byte("iPXE"),
+		}},
+		{"offer", map[byte][]byte{
+			optMessageType: {dhcpOffer},
+			optSubnetMask:  net.IPv4(255, 255, 255, 0).To4(),
+			optRouter:      net.IPv4(10, 0, 0, 1).To4(),
+			optDNS:         ipListOption([]net.IP{net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)}),
+			optLeaseTime:   uint32Option(3600),
+			optBootFile:    []byte("ipxe.efi"),
+		}},
+		{"option longer than 255 bytes", map[byte][]byte{
+			optVendorOpts: bytes.Repeat([]byte{7}, 600),
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := &packet{
+				Op:      bootReply,
+				HType:   1,
+				HLen:    6,
+				Hops:    2,
+				XID:     0xdeadbeef,
+				Secs:    3,
+				Flags:   0x8000,
+				CIAddr:  net.IPv4(0, 0, 0, 0).To4(),
+				YIAddr:  net.IPv4(10, 0, 0, 20).To4(),
+				SIAddr:  net.IPv4(10, 0, 0, 1).To4(),
+				GIAddr:  net.IPv4(10, 0, 1, 1).To4(),
+				CHAddr:  mac,
+				Options: tt.options,
+			}
+
+			b := want.marshal()
+			if len(b) < 300 {
+				t.Fatalf("marshalled packet is %d bytes, want at least 300", len(b))
+			}
+
+			got, err := parsePacket(b)
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestParsePacket(t *testing.T) {
+	header := func(opts ...byte) []byte {
+		b := make([]byte, 240)
+		b[2] = 6
+		copy(b[236:], magicCookie)
+		return append(b, opts...)
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		err     error
+		options map[byte][]byte
+	}{
+		{"too short", make([]byte, 239), errShortPacket, nil},
+		{"no cookie", make([]byte, 300), errBadCookie, nil},
+		{"pad and end", header(optPad, optMessageType, 1, dhcpRequest, optEnd, optHostname, 1, 'x'),
+			nil, map[byte][]byte{optMessageType: {dhcpRequest}}},
+		{"repeated option", header(optUserClass, 2, 'i', 'P', optUserClass, 2, 'X', 'E'),
+			nil, map[byte][]byte{optUserClass: []byte("iPXE")}},
+		{"truncated option", header(optMessageType, 1, dhcpInform, optHostname, 5, 'a'),
+			nil, map[byte][]byte{optMessageType: {dhcpInform}}},
+		{"option code without length", header(optMessageType, 1, dhcpRelease, optHostname),
+			nil, map[byte][]byte{optMessageType: {dhcpRelease}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := parsePacket(tt.data)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(p.Options, tt.options) {
+				t.Fatalf("got options %v, want %v", p.Options, tt.options)
+			}
+		})
+	}
+}
+
+func TestPacketOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  map[byte][]byte
+		msgType  byte
+		arch     uint16
+		hasArch  bool
+		serverID net.IP
+	}{
+		{"empty", map[byte][]byte{}, 0, 0, false, nil},
+		{"request", map[byte][]byte{
+			optMessageType: {dhcpRequest},
+			optClientArch:  {0, 11},
+			optServerID:    {10, 0, 0, 1},
+		}, dhcpRequest, 11, true, net.IPv4(10, 0, 0, 1).To4()},
+		{"malformed", map[byte][]byte{
+			optMessageType: {dhcpRequest, dhcpAck},
+			optClientArch:  {7},
+			optServerID:    {10, 0, 0},
+		}, 0, 0, false, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &packet{Options: tt.options}
+			if got := p.messageType(); got != tt.msgType {
+				t.Errorf("messageType() = %d, want %d", got, tt.msgType)
+			}
+			if arch, ok := p.clientArch(); arch != tt.arch || ok != tt.hasArch {
+				t.Errorf("clientArch() = %d, %v, want %d, %v", arch, ok, tt.arch, tt.hasArch)
+			}
+			if got := p.ipOption(optServerID); !got.Equal(tt.serverID) {
+				t.Errorf("ipOption(optServerID) = %v, want %v", got, tt.serverID)
+			}
+		})
+	}
+}
@@ -0,0 +1,307 @@
+package netboot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"pxehub/internal/ipxe"
+)
+
+// Scope is a DHCP range served on one interface. Leases and boot requests
+// from its clients are recorded under its Name.
+type Scope struct {
+	Name        string
+	Iface       string
+	RangeStart  string
+	RangeEnd    string
+	Mask        string
+	Router      string
+	Nameservers []string
+	// ProxySubnet is the subnet served in proxy DHCP mode, which ignores
+	// the range, router and nameservers.
+	ProxySubnet string
+	// DefaultTask names the task booted by registered hosts in this scope
+	// that have no task of their own.
+	DefaultTask string
+}
+
+// Equal reports whether both scopes are configured the same.
+func (s Scope) Equal(other Scope) bool {
+	return s.Name == other.Name &&
+		s.Iface == other.Iface &&
+		s.RangeStart == other.RangeStart &&
+		s.RangeEnd == other.RangeEnd &&
+		s.Mask == other.Mask &&
+		s.Router == other.Router &&
+		slices.Equal(s.Nameservers, other.Nameservers) &&
+		s.ProxySubnet == other.ProxySubnet &&
+		s.DefaultTask == other.DefaultTask
+}
+
+// Subnet returns the subnet of the DHCP range, or of ProxySubnet if it is
+// set. It is nil if the address or mask is not a valid IPv4 address.
+func (s Scope) Subnet() *net.IPNet {
+	addr := s.RangeStart
+	if s.ProxySubnet != "" {
+		addr = s.ProxySubnet
+	}
+
+	ip := net.ParseIP(addr).To4()
+	mask := net.ParseIP(s.Mask).To4()
+	if ip == nil || mask == nil {
+		return nil
+	}
+
+	ipMask := net.IPMask(mask)
+	return &net.IPNet{IP: ip.Mask(ipMask), Mask: ipMask}
+}
+
+// Lease describes a DHCP lease handed out or released by the server. A
+// released lease has Expires set to the time of release.
+type Lease struct {
+	Scope       string
+	Mac         string
+	IP          string
+	Hostname    string
+	VendorClass string
+	ClientArch  int // -1 if the client did not send option 93
+	Expires     time.Time
+}
+
+// Reservation pins a MAC address to an IP address, a hostname, or both.
+type Reservation struct {
+	Mac      string
+	IP       string
+	Hostname string
+}
+
+// Server states reported in Status.
+const (
+	StateRunning    = "running"
+	StateRestarting = "restarting"
+	StateFailed     = "failed"
+	StateStopped    = "stopped"
+)
+
+// Status describes a DHCP and TFTP server. PID and LastExitCode only apply to
+// servers run as a child process.
+type Status struct {
+	Server       string
+	State        string
+	PID          int
+	StartedAt    time.Time
+	Crashes      int
+	LastExitCode *int
+	LastError    string
+}
+
+// NetbootServer is a built-in DHCPv4 and TFTP server, serving the same
+// configuration as dnsmasq.DnsmasqServer without an external binary. It
+// serves a single scope; run one per interface for more.
+type NetbootServer struct {
+	Scope
+	TFTPDir string
+	IPXE    ipxe.Options
+	// BootFiles maps client architectures to boot files in TFTPDir.
+	BootFiles map[uint16]string
+	OnLease   func(Lease)
+
+	serverIP     net.IP
+	mask         []byte
+	mu           sync.Mutex
+	pool         *leasePool
+	reservations []Reservation
+	leases       []Lease
+	startedAt    time.Time
+	dhcpConn     net.PacketConn
+	tftpConn     net.PacketConn
+	stopped      atomic.Bool
+	wg           sync.WaitGroup
+}
+
+func (s *NetbootServer) stopping() bool {
+	return s.stopped.Load()
+}
+
+// interfaceIP returns the interface's IPv4 address on the subnet of the DHCP
+// range, falling back to its first IPv4 address.
+func interfaceIP(iface *net.Interface, rangeStart net.IP) (net.IP, error) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	var first net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.To4() == nil {
+			continue
+		}
+		if ipNet.Contains(rangeStart) {
+			return ipNet.IP.To4(), nil
+		}
+		if first == nil {
+			first = ipNet.IP.To4()
+		}
+	}
+
+	if first == nil {
+		return nil, fmt.Errorf("interface %s has no IPv4 address", iface.Name)
+	}
+	return first, nil
+}
+
+func (s *NetbootServer) listen(port int) (net.PacketConn, error) {
+	lc := net.ListenConfig{Control: bindToDevice(s.Iface)}
+	return lc.ListenPacket(context.Background(), "udp4", fmt.Sprintf("0.0.0.0:%d", port))
+}
+
+// parseConfig checks the interface and DHCP range, returning the server's
+// address, the subnet mask and an empty lease pool.
+func (s *NetbootServer) parseConfig() (net.IP, net.IP, *leasePool, error) {
+	iface, err := net.InterfaceByName(s.Iface)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("interface %q: %w", s.Iface, err)
+	}
+
+	rangeStart := net.ParseIP(s.RangeStart)
+	rangeEnd := net.ParseIP(s.RangeEnd)
+	mask := net.ParseIP(s.Mask)
+	if rangeStart == nil || rangeEnd == nil || mask == nil || mask.To4() == nil {
+		return nil, nil, nil, fmt.Errorf("invalid dhcp range %s-%s/%s", s.RangeStart, s.RangeEnd, s.Mask)
+	}
+
+	pool, err := newLeasePool(rangeStart, rangeEnd)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	serverIP, err := interfaceIP(iface, rangeStart)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return serverIP, mask.To4(), pool, nil
+}
+
+// Test checks the configuration without starting the server.
+func (s *NetbootServer) Test() error {
+	_, _, _, err := s.parseConfig()
+	return err
+}
+
+func (s *NetbootServer) Start() error {
+	if err := ipxe.PrepareTFTP(s.TFTPDir, s.BootFiles, s.IPXE); err != nil {
+		return err
+	}
+
+	serverIP, mask, pool, err := s.parseConfig()
+	if err != nil {
+		return err
+	}
+	s.serverIP, s.mask = serverIP, mask
+
+	s.mu.Lock()
+	pool.reserve(s.reservations)
+	pool.load(s.leases)
+	s.pool = pool
+	s.mu.Unlock()
+
+	s.dhcpConn, err = s.listen(67)
+	if err != nil {
+		return fmt.Errorf("failed to listen for dhcp: %w", err)
+	}
+
+	s.tftpConn, err = s.listen(69)
+	if err != nil {
+		s.dhcpConn.Close()
+		return fmt.Errorf("failed to listen for tftp: %w", err)
+	}
+
+	s.stopped.Store(false)
+	s.mu.Lock()
+	s.startedAt = time.Now()
+	s.mu.Unlock()
+	s.wg.Add(2)
+	go s.serveDHCP()
+	go s.serveTFTP()
+
+	log.Printf(
+		"Starting built-in dhcp/tftp for scope %s on iface %s (%s) serving %s-%s (router %s, nameservers %v, TFTPDir %s)",
+		s.Name, s.Iface, s.serverIP, s.RangeStart, s.RangeEnd, s.Router, s.Nameservers, s.TFTPDir,
+	)
+
+	return nil
+}
+
+// SetReservations replaces the static reservations. It may be called before
+// or after Start.
+func (s *NetbootServer) SetReservations(reservations []Reservation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.reservations = reservations
+	if s.pool != nil {
+		s.pool.reserve(reservations)
+	}
+
+	return nil
+}
+
+// SetLeases sets the leases to load into the lease pool when the server
+// starts. Leases of other scopes are ignored.
+func (s *NetbootServer) SetLeases(leases []Lease) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.leases = nil
+	for _, lease := range leases {
+		if lease.Scope == s.Name {
+			s.leases = append(s.leases, lease)
+		}
+	}
+
+	return nil
+}
+
+func (s *NetbootServer) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := Status{Server: "builtin", State: StateStopped}
+	if !s.startedAt.IsZero() && !s.stopping() {
+		status.State = StateRunning
+		status.StartedAt = s.startedAt
+	}
+	return status
+}
+
+func (s *NetbootServer) Stop() error {
+	if s.dhcpConn == nil {
+		return fmt.Errorf("server not running")
+	}
+
+	s.stopped.Store(true)
+	s.dhcpConn.Close()
+	s.tftpConn.Close()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		log.Printf("tftp transfers did not finish, stopping anyway")
+	}
+
+	return nil
+}
@@ -0,0 +1,239 @@
+package netboot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TFTP opcodes (RFC 1350, RFC 2347).
+const (
+	tftpRRQ   = 1
+	tftpWRQ   = 2
+	tftpDATA  = 3
+	tftpACK   = 4
+	tftpERROR = 5
+	tftpOACK  = 6
+)
+
+// TFTP error codes.
+const (
+	tftpErrNotDefined   = 0
+	tftpErrNotFound     = 1
+	tftpErrAccess       = 2
+	tftpErrBadOperation = 4
+)
+
+const defaultBlockSize = 512
+
+// maxBlockSize keeps blocks inside a single Ethernet frame.
+const maxBlockSize = 1468
+
+const tftpRetries = 5
+
+func (s *NetbootServer) serveTFTP() {
+	defer s.wg.Done()
+
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := s.tftpConn.ReadFrom(buf)
+		if err != nil {
+			if s.stopping() {
+				return
+			}
+			log.Printf("tftp read error: %v", err)
+			continue
+		}
+
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok || n < 2 {
+			continue
+		}
+
+		pkt := append([]byte(nil), buf[:n]...)
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handleTFTP(udpAddr, pkt)
+		}()
+	}
+}
+
+func tftpError(code uint16, msg string) []byte {
+	b := make([]byte, 4, 5+len(msg))
+	binary.BigEndian.PutUint16(b[0:2], tftpERROR)
+	binary.BigEndian.PutUint16(b[2:4], code)
+	b = append(b, msg...)
+	return append(b, 0)
+}
+
+// parseRRQ splits a read request into its file name, mode and options.
+func parseRRQ(b []byte) (string, string, map[string]string, error) {
+	fields := bytes.Split(b, []byte{0})
+	if len(fields) < 3 {
+		return "", "", nil, errors.New("malformed request")
+	}
+
+	opts := map[string]string{}
+	for i := 2; i+1 < len(fields); i += 2 {
+		if len(fields[i]) == 0 {
+			break
+		}
+		opts[strings.ToLower(string(fields[i]))] = string(fields[i+1])
+	}
+
+	return string(fields[0]), strings.ToLower(string(fields[1])), opts, nil
+}
+
+// resolvePath maps a requested name onto a file inside the TFTP root,
+// refusing anything that would escape it.
+func (s *NetbootServer) resolvePath(name string) string {
+	name = strings.ReplaceAll(name, "\\", "/")
+	return filepath.Join(s.TFTPDir, filepath.Clean("/"+name))
+}
+
+func (s *NetbootServer) handleTFTP(addr *net.UDPAddr, pkt []byte) {
+	// Each transfer gets its own port (its transfer ID).
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: s.serverIP})
+	if err != nil {
+		log.Printf("tftp: failed to open transfer socket: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	opcode := binary.BigEndian.Uint16(pkt[0:2])
+	if opcode == tftpWRQ {
+		conn.WriteToUDP(tftpError(tftpErrAccess, "read only server"), addr)
+		return
+	} else if opcode != tftpRRQ {
+		conn.WriteToUDP(tftpError(tftpErrBadOperation, "expected read request"), addr)
+		return
+	}
+
+	name, _, opts, err := parseRRQ(pkt[2:])
+	if err != nil {
+		conn.WriteToUDP(tftpError(tftpErrNotDefined, err.Error()), addr)
+		return
+	}
+
+	file, err := os.Open(s.resolvePath(name))
+	if err != nil {
+		log.Printf("tftp: %s requested %s: not found", addr.IP, name)
+		conn.WriteToUDP(tftpError(tftpErrNotFound, "file not found"), addr)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		conn.WriteToUDP(tftpError(tftpErrNotFound, "file not found"), addr)
+		return
+	}
+
+	log.Printf("tftp: sending %s to %s", name, addr.IP)
+
+	blockSize := defaultBlockSize
+	timeout := time.Second
+	oack := map[string]string{}
+
+	if val, ok := opts["blksize"]; ok {
+		if n, err := strconv.Atoi(val); err == nil && n >= 8 {
+			blockSize = min(n, maxBlockSize)
+			oack["blksize"] = strconv.Itoa(blockSize)
+		}
+	}
+	if val, ok := opts["timeout"]; ok {
+		if n, err := strconv.Atoi(val); err == nil && n >= 1 && n <= 255 {
+			timeout = time.Duration(n) * time.Second
+			oack["timeout"] = val
+		}
+	}
+	if _, ok := opts["tsize"]; ok {
+		oack["tsize"] = strconv.FormatInt(info.Size(), 10)
+	}
+
+	if len(oack) > 0 {
+		b := make([]byte, 2, 64)
+		binary.BigEndian.PutUint16(b, tftpOACK)
+		for k, v := range oack {
+			b = append(b, k...)
+			b = append(b, 0)
+			b = append(b, v...)
+			b = append(b, 0)
+		}
+		if err := sendAndWaitACK(conn, addr, b, 0, timeout); err != nil {
+			log.Printf("tftp: %s aborted %s: %v", addr.IP, name, err)
+			return
+		}
+	}
+
+	data := make([]byte, blockSize)
+	for block := uint16(1); ; block++ {
+		n, err := io.ReadFull(file, data)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			conn.WriteToUDP(tftpError(tftpErrNotDefined, "read error"), addr)
+			return
+		}
+
+		b := make([]byte, 4+n)
+		binary.BigEndian.PutUint16(b[0:2], tftpDATA)
+		binary.BigEndian.PutUint16(b[2:4], block)
+		copy(b[4:], data[:n])
+
+		if err := sendAndWaitACK(conn, addr, b, block, timeout); err != nil {
+			log.Printf("tftp: %s aborted %s: %v", addr.IP, name, err)
+			return
+		}
+
+		// A short block ends the transfer.
+		if n < blockSize {
+			return
+		}
+	}
+}
+
+// sendAndWaitACK sends b until the client acknowledges block, retrying on
+// timeout.
+func sendAndWaitACK(conn *net.UDPConn, addr *net.UDPAddr, b []byte, block uint16, timeout time.Duration) error {
+	buf := make([]byte, 516)
+
+	for try := 0; try < tftpRetries; try++ {
+		if _, err := conn.WriteToUDP(b, addr); err != nil {
+			return err
+		}
+
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					break
+				}
+				return err
+			}
+			if !from.IP.Equal(addr.IP) || from.Port != addr.Port || n < 4 {
+				continue
+			}
+
+			switch binary.BigEndian.Uint16(buf[0:2]) {
+			case tftpACK:
+				if binary.BigEndian.Uint16(buf[2:4]) == block {
+					return nil
+				}
+			case tftpERROR:
+				return errors.New("client sent error: " + string(bytes.TrimRight(buf[4:n], "\x00")))
+			}
+		}
+	}
+
+	return errors.New("timed out")
+}
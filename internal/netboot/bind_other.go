@@ -0,0 +1,22 @@
+//go:build !linux
+
+package netboot
+
+import (
+	"syscall"
+)
+
+// bindToDevice only enables broadcasts outside Linux, where sockets cannot
+// be bound to an interface.
+func bindToDevice(iface string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_BROADCAST, 1)
+		})
+		if err != nil {
+			return err
+		}
+		return sockErr
+	}
+}
@@ -1,48 +1,139 @@
 package main
 
 import (
-	"bufio"
+	"flag"
 	"fmt"
 	"log"
+	"maps"
 	"os"
 	"os/signal"
-	"strings"
+	"path/filepath"
 	"syscall"
 
+	"pxehub/internal/bootserver"
+	"pxehub/internal/config"
 	"pxehub/internal/db"
 	"pxehub/internal/dnsmasq"
 	httpserver "pxehub/internal/http"
+	"pxehub/internal/ipxe"
+	"pxehub/internal/netboot"
+
+	"gorm.io/gorm"
 )
 
-func readConf(path string) (map[string]string, error) {
-	file, err := os.Open(path)
+// reservations returns the static reservations of all hosts.
+func reservations(database *gorm.DB) ([]netboot.Reservation, error) {
+	hosts, err := db.GetReservations(database)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
 
-	conf := make(map[string]string)
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) != 2 {
-			continue
+	reservations := make([]netboot.Reservation, 0, len(hosts))
+	for _, host := range hosts {
+		reservation := netboot.Reservation{Mac: host.Mac, Hostname: host.DHCPHostname}
+		if host.ReservedIP != nil {
+			reservation.IP = *host.ReservedIP
 		}
-		conf[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		reservations = append(reservations, reservation)
+	}
+
+	return reservations, nil
+}
+
+// leases returns the recorded leases that have not expired.
+func leases(database *gorm.DB) ([]netboot.Lease, error) {
+	saved, err := db.GetLeases(database)
+	if err != nil {
+		return nil, err
+	}
+
+	leases := make([]netboot.Lease, 0, len(saved))
+	for _, lease := range saved {
+		leases = append(leases, netboot.Lease{
+			Scope:       lease.Scope,
+			Mac:         lease.Mac,
+			IP:          lease.IP,
+			Hostname:    lease.Hostname,
+			VendorClass: lease.VendorClass,
+			ClientArch:  lease.ClientArch,
+			Expires:     lease.ExpiresAt,
+		})
+	}
+
+	return leases, nil
+}
+
+// bootConfig returns the DHCP and TFTP settings of cfg.
+func bootConfig(cfg *config.Config) bootserver.Config {
+	scopes := make([]netboot.Scope, 0, len(cfg.Scopes))
+	for _, scope := range cfg.Scopes {
+		scopes = append(scopes, netboot.Scope{
+			Name:        scope.Name,
+			Iface:       scope.Interface,
+			RangeStart:  scope.RangeStart,
+			RangeEnd:    scope.RangeEnd,
+			Mask:        scope.Mask,
+			Router:      scope.Router,
+			Nameservers: scope.DNSServers,
+			ProxySubnet: scope.ProxySubnet,
+			DefaultTask: scope.DefaultTask,
+		})
 	}
 
-	return conf, scanner.Err()
+	bootFiles := cfg.BootFiles
+	if len(bootFiles) == 0 {
+		bootFiles = maps.Clone(ipxe.DefaultBootFiles)
+	}
+
+	return bootserver.Config{
+		Server:  cfg.DHCPServer,
+		Scopes:  scopes,
+		TFTPDir: cfg.TFTPDir,
+		IPXE: ipxe.Options{
+			Offline:   cfg.IPXE.Offline,
+			SourceDir: cfg.IPXE.Dir,
+			SHA256:    cfg.IPXE.SHA256,
+		},
+		BootFiles:  bootFiles,
+		Proxy:      cfg.Proxy(),
+		RuntimeDir: cfg.RuntimeDir,
+	}
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == dnsmasq.LeaseScriptCommand {
+		if err := dnsmasq.RunLeaseScript(os.Args[2:]); err != nil {
+			log.Fatalf("dhcp-script: %v", err)
+		}
+		return
+	}
+
+	os.Exit(runCommand(os.Args[1:]))
+}
+
+// serve runs the DHCP, TFTP and HTTP servers until it is interrupted.
+func serve(fs *flag.FlagSet, args []string) error {
+	paths := addPathFlags(fs)
+	if err := parseArgs(fs, args, 0, 0); err != nil {
+		return err
+	}
+
+	path, err := paths.configPath()
+	if err != nil {
+		return err
+	}
+	overrides := paths.overrides()
+	cfg, err := config.Load(path, overrides)
+	if err != nil {
+		return fmt.Errorf("invalid config:\n%w", err)
+	}
+
 	dirs := []string{
-		"/opt/pxehub",
-		"/opt/pxehub/http",
-		"/opt/pxehub/tftp",
+		cfg.DataDir,
+		cfg.ExtrasDir,
+		cfg.TFTPDir,
+		cfg.RuntimeDir,
+		filepath.Dir(cfg.DBPath),
 	}
 
 	for _, dir := range dirs {
@@ -58,42 +149,59 @@ func main() {
 		}
 	}
 
-	conf, err := readConf("/opt/pxehub/pxehub.conf")
-	if err != nil {
-		log.Println("Error reading conf:", err)
-		return
-	}
-
-	dnsList := []string{}
-	if val, ok := conf["DNS_SERVERS"]; ok {
-		for _, ip := range strings.Split(val, ",") {
-			ip = strings.TrimSpace(ip)
-			if ip != "" {
-				dnsList = append(dnsList, ip)
-			}
+	database := db.OpenDB(cfg.DBPath)
+
+	onLease := func(lease netboot.Lease) {
+		err := db.SaveLease(db.Lease{
+			Scope:       lease.Scope,
+			Mac:         lease.Mac,
+			IP:          lease.IP,
+			Hostname:    lease.Hostname,
+			VendorClass: lease.VendorClass,
+			ClientArch:  lease.ClientArch,
+			ExpiresAt:   lease.Expires,
+		}, database)
+		if err != nil {
+			log.Printf("failed to save lease for %s: %v", lease.Mac, err)
 		}
 	}
 
-	database := db.OpenDB("/opt/pxehub/pxehub.db")
-
-	dhcpTftpServer := dnsmasq.DnsmasqServer{
-		Iface:       conf["INTERFACE"],
-		RangeStart:  conf["DHCP_RANGE_START"],
-		RangeEnd:    conf["DHCP_RANGE_END"],
-		Mask:        conf["DHCP_MASK"],
-		Router:      conf["DHCP_ROUTER"],
-		Nameservers: dnsList,
-		TFTPDir:     "/opt/pxehub/tftp",
+	dhcpTftpServer := &bootserver.Manager{
+		Load: func() (bootserver.Config, error) {
+			cfg, err := config.Load(path, overrides)
+			if err != nil {
+				return bootserver.Config{}, err
+			}
+			return bootConfig(cfg), nil
+		},
+		Reservations: func() ([]netboot.Reservation, error) {
+			return reservations(database)
+		},
+		Leases: func() ([]netboot.Lease, error) {
+			return leases(database)
+		},
+		OnLease: onLease,
 	}
 
 	httpServer := httpserver.HttpServer{
-		Address:   conf["HTTP_BIND"],
-		Database:  database,
-		ExtrasDir: "/opt/pxehub/http",
+		Address:    cfg.HTTPBind,
+		Database:   database,
+		ExtrasDir:  cfg.ExtrasDir,
+		ScriptVars: cfg.ScriptVars,
+		Scopes: func() []netboot.Scope {
+			return dhcpTftpServer.Config().Scopes
+		},
+		ReloadDHCP: dhcpTftpServer.Reload,
+		DHCPStatus: dhcpTftpServer.Status,
+		OnHostsChanged: func() {
+			if err := dhcpTftpServer.Reload(); err != nil {
+				log.Printf("failed to reload dhcp/tftp: %v", err)
+			}
+		},
 	}
 
 	if err := dhcpTftpServer.Start(); err != nil {
-		fmt.Printf("dnsmasq failed: %v", err)
+		fmt.Printf("dhcp/tftp failed: %v", err)
 		syscall.Kill(os.Getpid(), syscall.SIGTERM)
 	}
 
@@ -103,15 +211,26 @@ func main() {
 	}
 
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 
-	<-c
-	log.Println("Shutting down dnsmasq...")
+	for sig := range c {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		log.Println("Reloading dhcp/tftp...")
+		if err := dhcpTftpServer.Reload(); err != nil {
+			log.Printf("failed to reload dhcp/tftp: %v", err)
+		}
+	}
+
+	log.Println("Shutting down dhcp/tftp...")
 	if err := dhcpTftpServer.Stop(); err != nil {
-		log.Printf("failed to stop dnsmasq: %v", err)
+		log.Printf("failed to stop dhcp/tftp: %v", err)
 	}
 	log.Println("Shutting down http...")
 	if err := httpServer.Stop(); err != nil {
 		log.Printf("failed to stop http: %v", err)
 	}
+
+	return nil
 }